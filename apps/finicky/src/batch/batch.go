@@ -0,0 +1,121 @@
+package batch
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"finicky/browser"
+	"finicky/config"
+	"finicky/resolver"
+)
+
+// maxLineSize bounds a single input line. Long URLs (tracking links, data
+// URLs) easily exceed bufio.Scanner's 64KB default.
+const maxLineSize = 1024 * 1024
+
+// Request is a single URL to resolve, read from one input line.
+type Request struct {
+	URL              string               `json:"url"`
+	Opener           *resolver.OpenerInfo `json:"opener,omitempty"`
+	OpenInBackground bool                 `json:"openInBackground,omitempty"`
+}
+
+// Result is the routing decision for a single Request.
+type Result struct {
+	URL        string                 `json:"url"`
+	Opener     *resolver.OpenerInfo   `json:"opener,omitempty"`
+	Browser    *browser.BrowserConfig `json:"browser,omitempty"`
+	Error      string                 `json:"error,omitempty"`
+	DurationMs *float64               `json:"durationMs,omitempty"`
+}
+
+// Options controls how Run writes results.
+type Options struct {
+	// Timing adds the per-URL evaluation time to each result. Disable it to
+	// get byte-for-byte stable output, e.g. for golden files.
+	Timing bool
+}
+
+// ParseRequest parses one input line. A line is either a plain URL or a JSON
+// object with url, opener and openInBackground fields.
+func ParseRequest(line string) (Request, error) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "{") {
+		return Request{URL: line}, nil
+	}
+
+	var req Request
+	if err := json.Unmarshal([]byte(line), &req); err != nil {
+		return Request{}, fmt.Errorf("invalid JSON request: %v", err)
+	}
+	if req.URL == "" {
+		return Request{}, fmt.Errorf("request is missing url")
+	}
+	return req, nil
+}
+
+// Resolve evaluates a single request against vm.
+func Resolve(vm *config.VM, req Request) Result {
+	startTime := time.Now()
+	cfg, err := resolver.ResolveURL(vm, req.URL, req.Opener, req.OpenInBackground)
+	duration := float64(time.Since(startTime).Microseconds()) / 1000
+
+	result := Result{
+		URL:        req.URL,
+		Opener:     req.Opener,
+		Browser:    cfg,
+		DurationMs: &duration,
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// Run resolves each line of r against vm and writes one JSON result per line
+// to w, in input order. It returns how many results carry an error.
+func Run(vm *config.VM, r io.Reader, w io.Writer, opts Options) (int, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	out := bufio.NewWriter(w)
+	encoder := json.NewEncoder(out)
+
+	failures := 0
+	for scanner.Scan() {
+		// Skip blank lines and comments
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var result Result
+		req, err := ParseRequest(line)
+		if err != nil {
+			result = Result{URL: line, Error: err.Error()}
+		} else {
+			result = Resolve(vm, req)
+		}
+		if !opts.Timing {
+			result.DurationMs = nil
+		}
+		if result.Error != "" {
+			failures++
+		}
+
+		if err := encoder.Encode(result); err != nil {
+			return failures, fmt.Errorf("failed to write result: %v", err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		out.Flush()
+		return failures, fmt.Errorf("failed to read input: %v", err)
+	}
+	if err := out.Flush(); err != nil {
+		return failures, fmt.Errorf("failed to write results: %v", err)
+	}
+	return failures, nil
+}
@@ -0,0 +1,121 @@
+package batch_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	. "finicky/batch"
+	"finicky/internal/testvm"
+	"finicky/rules"
+)
+
+func TestParseRequest_PlainURL(t *testing.T) {
+	req, err := ParseRequest("  https://example.com/path  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.URL != "https://example.com/path" {
+		t.Errorf("url: got %q", req.URL)
+	}
+	if req.Opener != nil {
+		t.Errorf("expected no opener, got %+v", req.Opener)
+	}
+}
+
+func TestParseRequest_JSON(t *testing.T) {
+	req, err := ParseRequest(`{"url": "https://example.com", "opener": {"name": "Slack", "bundleId": "com.tinyspeck.slackmacgap", "path": "/Applications/Slack.app"}, "openInBackground": true}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.URL != "https://example.com" {
+		t.Errorf("url: got %q", req.URL)
+	}
+	if req.Opener == nil || req.Opener.BundleID != "com.tinyspeck.slackmacgap" {
+		t.Errorf("opener: got %+v", req.Opener)
+	}
+	if !req.OpenInBackground {
+		t.Error("expected openInBackground=true")
+	}
+}
+
+func TestParseRequest_Invalid(t *testing.T) {
+	for _, line := range []string{`{"url": `, `{"opener": {"name": "Slack"}}`} {
+		if _, err := ParseRequest(line); err == nil {
+			t.Errorf("expected error for %q", line)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	vm := testvm.Rules(t, rules.RulesFile{
+		DefaultBrowser: "Safari",
+		Rules: []rules.Rule{
+			{Match: []string{"*github.com/*"}, Browser: "Firefox"},
+		},
+	})
+
+	input := strings.Join([]string{
+		"# comment",
+		"https://github.com/johnste/finicky",
+		"",
+		`{"url": "https://example.com", "opener": {"name": "Slack", "bundleId": "com.tinyspeck.slackmacgap", "path": "/Applications/Slack.app"}}`,
+		`{"url": `,
+	}, "\n")
+
+	var out bytes.Buffer
+	failures, err := Run(vm, strings.NewReader(input), &out, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failures != 1 {
+		t.Errorf("failures: got %d, want 1", failures)
+	}
+
+	var results []Result
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var r Result
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("invalid output line %q: %v", scanner.Text(), err)
+		}
+		results = append(results, r)
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected 3 results, got %d", len(results))
+	}
+
+	if results[0].Browser == nil || results[0].Browser.Name != "Firefox" {
+		t.Errorf("result 0: got %+v", results[0].Browser)
+	}
+	if results[1].Browser == nil || results[1].Browser.Name != "Safari" {
+		t.Errorf("result 1: got %+v", results[1].Browser)
+	}
+	if results[1].Opener == nil || results[1].Opener.Name != "Slack" {
+		t.Errorf("result 1 opener: got %+v", results[1].Opener)
+	}
+	if results[2].Error == "" || results[2].Browser != nil {
+		t.Errorf("result 2: expected parse error, got %+v", results[2])
+	}
+	for i, r := range results {
+		if r.DurationMs != nil {
+			t.Errorf("result %d: expected no timing when disabled", i)
+		}
+	}
+}
+
+func TestRun_Timing(t *testing.T) {
+	var out bytes.Buffer
+	if _, err := Run(nil, strings.NewReader("https://example.com\n"), &out, Options{Timing: true}); err != nil {
+		t.Fatal(err)
+	}
+	var r Result
+	if err := json.Unmarshal(out.Bytes(), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.DurationMs == nil {
+		t.Error("expected durationMs to be set")
+	}
+}
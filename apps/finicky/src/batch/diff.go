@@ -0,0 +1,200 @@
+package batch
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"finicky/browser"
+	"finicky/config"
+	"finicky/resolver"
+)
+
+// Decision is the part of a routing result that is compared between two
+// configurations.
+type Decision struct {
+	Browser   string     `json:"browser"`
+	AppType   string     `json:"appType"`
+	Profile   string     `json:"profile"`
+	Args      []string   `json:"args"`
+	URL       string     `json:"url"`
+	Private   bool       `json:"private"`
+	AppWindow bool       `json:"appWindow"`
+	Fallbacks []Decision `json:"fallbacks,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+// Change is a request that is routed differently by the new configuration.
+type Change struct {
+	URL    string               `json:"url"`
+	Opener *resolver.OpenerInfo `json:"opener,omitempty"`
+	Old    Decision             `json:"old"`
+	New    Decision             `json:"new"`
+	Fields []string             `json:"fields"`
+}
+
+// ReadRequests reads all requests from r, skipping blank lines and lines
+// starting with #. Unlike Run, a line that fails to parse is an error.
+func ReadRequests(r io.Reader) ([]Request, error) {
+	var requests []Request
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		req, err := ParseRequest(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNumber, err)
+		}
+		requests = append(requests, req)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read input: %v", err)
+	}
+	return requests, nil
+}
+
+// Compare resolves every request against both VMs and returns the requests
+// whose decision differs, in input order.
+func Compare(oldVM *config.VM, newVM *config.VM, requests []Request) []Change {
+	var changes []Change
+	for _, req := range requests {
+		oldDecision := decide(oldVM, req)
+		newDecision := decide(newVM, req)
+		if fields := changedFields(oldDecision, newDecision); len(fields) > 0 {
+			changes = append(changes, Change{
+				URL:    req.URL,
+				Opener: req.Opener,
+				Old:    oldDecision,
+				New:    newDecision,
+				Fields: fields,
+			})
+		}
+	}
+	return changes
+}
+
+func decide(vm *config.VM, req Request) Decision {
+	cfg, err := resolver.ResolveURL(vm, req.URL, req.Opener, req.OpenInBackground)
+	decision := decisionFromConfig(cfg)
+	if err != nil {
+		decision.Error = err.Error()
+	}
+	return decision
+}
+
+func decisionFromConfig(cfg *browser.BrowserConfig) Decision {
+	args := cfg.Args
+	if args == nil {
+		args = []string{}
+	}
+	decision := Decision{
+		Browser:   cfg.Name,
+		AppType:   cfg.AppType,
+		Profile:   cfg.Profile,
+		Args:      args,
+		URL:       cfg.URL,
+		Private:   cfg.Private,
+		AppWindow: cfg.AppWindow,
+	}
+	for i := range cfg.Fallbacks {
+		decision.Fallbacks = append(decision.Fallbacks, decisionFromConfig(&cfg.Fallbacks[i]))
+	}
+	return decision
+}
+
+func changedFields(a Decision, b Decision) []string {
+	var fields []string
+	if a.Browser != b.Browser {
+		fields = append(fields, "browser")
+	}
+	if a.AppType != b.AppType {
+		fields = append(fields, "appType")
+	}
+	if a.Profile != b.Profile {
+		fields = append(fields, "profile")
+	}
+	if !slices.Equal(a.Args, b.Args) {
+		fields = append(fields, "args")
+	}
+	if a.URL != b.URL {
+		fields = append(fields, "url")
+	}
+	if a.Private != b.Private {
+		fields = append(fields, "private")
+	}
+	if a.AppWindow != b.AppWindow {
+		fields = append(fields, "appWindow")
+	}
+	if !slices.EqualFunc(a.Fallbacks, b.Fallbacks, func(a Decision, b Decision) bool {
+		return len(changedFields(a, b)) == 0
+	}) {
+		fields = append(fields, "fallbacks")
+	}
+	if a.Error != b.Error {
+		fields = append(fields, "error")
+	}
+	return fields
+}
+
+func (d Decision) field(name string) interface{} {
+	switch name {
+	case "browser":
+		return d.Browser
+	case "appType":
+		return d.AppType
+	case "profile":
+		return d.Profile
+	case "args":
+		return d.Args
+	case "url":
+		return d.URL
+	case "private":
+		return d.Private
+	case "appWindow":
+		return d.AppWindow
+	case "fallbacks":
+		return d.Fallbacks
+	case "error":
+		return d.Error
+	}
+	return nil
+}
+
+// WriteChanges prints a human-readable summary of changes, listing the old and
+// new value of every field that differs.
+func WriteChanges(w io.Writer, changes []Change, total int) {
+	for _, c := range changes {
+		fmt.Fprintln(w, c.URL)
+		if c.Opener != nil {
+			fmt.Fprintf(w, "  opener: %s (%s)\n", c.Opener.Name, c.Opener.BundleID)
+		}
+		for _, field := range c.Fields {
+			fmt.Fprintf(w, "  %s: %s -> %s\n", field, formatField(c.Old.field(field)), formatField(c.New.field(field)))
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintf(w, "%d of %d URLs would open differently\n", len(changes), total)
+}
+
+// formatField quotes strings and string lists, and prints other values as
+// JSON.
+func formatField(value interface{}) string {
+	switch v := value.(type) {
+	case string, []string:
+		return fmt.Sprintf("%q", v)
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
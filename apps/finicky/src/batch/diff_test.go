@@ -0,0 +1,132 @@
+package batch_test
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	. "finicky/batch"
+	"finicky/internal/testvm"
+	"finicky/rules"
+)
+
+func TestReadRequests(t *testing.T) {
+	requests, err := ReadRequests(strings.NewReader("# corpus\nhttps://example.com\n\n{\"url\": \"https://github.com\"}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(requests))
+	}
+	if requests[1].URL != "https://github.com" {
+		t.Errorf("url: got %q", requests[1].URL)
+	}
+
+	if _, err := ReadRequests(strings.NewReader("https://example.com\n{\"url\": ")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("expected error for line 2, got %v", err)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	oldVM := testvm.Rules(t, rules.RulesFile{
+		DefaultBrowser: "Safari",
+		Rules: []rules.Rule{
+			{Match: []string{"*github.com/*"}, Browser: "Firefox"},
+			{Match: []string{"*linear.app/*"}, Browser: "Google Chrome"},
+		},
+	})
+	newVM := testvm.Rules(t, rules.RulesFile{
+		DefaultBrowser: "Safari",
+		Rules: []rules.Rule{
+			{Match: []string{"*github.com/*"}, Browser: "Firefox"},
+			{Match: []string{"*linear.app/*"}, Browser: "Google Chrome", Profile: "Work"},
+			{Match: []string{"*figma.com/*"}, Browser: "Google Chrome"},
+		},
+	})
+
+	requests := []Request{
+		{URL: "https://github.com/johnste/finicky"},
+		{URL: "https://linear.app/team/issue/1"},
+		{URL: "https://example.com"},
+		{URL: "https://www.figma.com/file/1"},
+	}
+
+	changes := Compare(oldVM, newVM, requests)
+	if len(changes) != 2 {
+		t.Fatalf("expected 2 changes, got %d: %+v", len(changes), changes)
+	}
+
+	if changes[0].URL != "https://linear.app/team/issue/1" {
+		t.Errorf("change 0: got %q", changes[0].URL)
+	}
+	if !reflect.DeepEqual(changes[0].Fields, []string{"profile"}) {
+		t.Errorf("change 0 fields: got %v", changes[0].Fields)
+	}
+	if changes[0].New.Profile != "Work" {
+		t.Errorf("change 0 new profile: got %q", changes[0].New.Profile)
+	}
+
+	if changes[1].Old.Browser != "Safari" || changes[1].New.Browser != "Google Chrome" {
+		t.Errorf("change 1: got %q -> %q", changes[1].Old.Browser, changes[1].New.Browser)
+	}
+
+	var out bytes.Buffer
+	WriteChanges(&out, changes, len(requests))
+	for _, want := range []string{
+		`profile: "" -> "Work"`,
+		`browser: "Safari" -> "Google Chrome"`,
+		"2 of 4 URLs would open differently",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestCompare_Fields(t *testing.T) {
+	const base = `{name: "Firefox"}`
+	cases := []struct {
+		name           string
+		defaultBrowser string
+		field          string
+		output         string
+	}{
+		{"appType", `{name: "Firefox", appType: "bundleId"}`, "appType", `appType: "appName" -> "bundleId"`},
+		{"private", `{name: "Firefox", private: true}`, "private", "private: false -> true"},
+		{"appWindow", `{name: "Firefox", appWindow: true}`, "appWindow", "appWindow: false -> true"},
+		{"fallbacks", `["Firefox", "Safari"]`, "fallbacks", `fallbacks: null -> [{"browser":"Safari"`},
+	}
+	oldVM := testvm.JS(t, `{defaultBrowser: `+base+`}`)
+	for _, c := range cases {
+		newVM := testvm.JS(t, `{defaultBrowser: `+c.defaultBrowser+`}`)
+		changes := Compare(oldVM, newVM, []Request{{URL: "https://example.com/"}})
+		if len(changes) != 1 || !reflect.DeepEqual(changes[0].Fields, []string{c.field}) {
+			t.Errorf("%s: expected %q to change, got %+v", c.name, c.field, changes)
+			continue
+		}
+		var out bytes.Buffer
+		WriteChanges(&out, changes, 1)
+		if !strings.Contains(out.String(), c.output) {
+			t.Errorf("%s: output missing %q:\n%s", c.name, c.output, out.String())
+		}
+	}
+
+	// Fallbacks are compared field by field.
+	oldVM = testvm.JS(t, `{defaultBrowser: ["Firefox", "Safari"]}`)
+	newVM := testvm.JS(t, `{defaultBrowser: ["Firefox", {name: "Safari", private: true}]}`)
+	changes := Compare(oldVM, newVM, []Request{{URL: "https://example.com/"}})
+	if len(changes) != 1 || !reflect.DeepEqual(changes[0].Fields, []string{"fallbacks"}) {
+		t.Errorf("fallback options: expected fallbacks to change, got %+v", changes)
+	}
+	if changes := Compare(oldVM, oldVM, []Request{{URL: "https://example.com/"}}); len(changes) != 0 {
+		t.Errorf("same fallbacks: expected no changes, got %+v", changes)
+	}
+}
+
+func TestCompare_SameConfig(t *testing.T) {
+	vm := testvm.Rules(t, rules.RulesFile{DefaultBrowser: "Safari"})
+	if changes := Compare(vm, vm, []Request{{URL: "https://example.com"}}); len(changes) != 0 {
+		t.Errorf("expected no changes, got %+v", changes)
+	}
+}
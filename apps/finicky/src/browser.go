@@ -8,7 +8,9 @@ package main
 import "C"
 
 import (
+	"finicky/browser"
 	"fmt"
+	"log/slog"
 	"unsafe"
 )
 
@@ -36,6 +38,13 @@ func setDefaultBrowser() (bool, error) {
 		return true, nil
 	}
 
+	// Remember the browser we replace, as a last resort for opening links
+	if previous, err := getDefaultHandlerForURLScheme("https"); err == nil && previous != bundleId {
+		if err := browser.RecordSystemDefault(previous); err != nil {
+			slog.Warn("Failed to record system default browser", "error", err)
+		}
+	}
+
 	setDefaultHandlerForURLScheme(bundleId, "http")
 	setDefaultHandlerForURLScheme(bundleId, "https")
 	setDefaultHandlerForURLScheme(bundleId, "finicky")
@@ -0,0 +1,285 @@
+package browser
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// DesktopEntry is an application's .desktop file, which is how Linux desktops
+// name and start applications.
+type DesktopEntry struct {
+	// ID is the desktop file ID, such as "firefox.desktop".
+	ID string `json:"id"`
+	// Name is the application's display name.
+	Name string `json:"name"`
+	// Exec is the command line that starts the application, with field codes
+	// such as %u still in it.
+	Exec string `json:"exec"`
+	// Path is the .desktop file on disk.
+	Path string `json:"path"`
+	// MimeTypes are the MIME types and URL schemes the application handles,
+	// such as "x-scheme-handler/https".
+	MimeTypes []string `json:"mimeTypes,omitempty"`
+	// NoDisplay hides the application from menus, and Hidden marks the entry
+	// as deleted.
+	NoDisplay bool `json:"noDisplay,omitempty"`
+	Hidden    bool `json:"hidden,omitempty"`
+}
+
+// ParseDesktopEntry parses the [Desktop Entry] group of a .desktop file.
+// Localized keys are ignored.
+func ParseDesktopEntry(id string, path string, data []byte) (DesktopEntry, error) {
+	entry := DesktopEntry{ID: id, Path: path}
+	inEntry := false
+	found := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			inEntry = line == "[Desktop Entry]"
+			found = found || inEntry
+			continue
+		}
+		if !inEntry {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		value = unescapeDesktopValue(strings.TrimSpace(value))
+		switch strings.TrimSpace(key) {
+		case "Name":
+			entry.Name = value
+		case "Exec":
+			entry.Exec = value
+		case "MimeType":
+			for _, mimeType := range strings.Split(value, ";") {
+				if mimeType = strings.TrimSpace(mimeType); mimeType != "" {
+					entry.MimeTypes = append(entry.MimeTypes, mimeType)
+				}
+			}
+		case "NoDisplay":
+			entry.NoDisplay = value == "true"
+		case "Hidden":
+			entry.Hidden = value == "true"
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return DesktopEntry{}, err
+	}
+	if !found {
+		return DesktopEntry{}, fmt.Errorf("%s: no [Desktop Entry] group", id)
+	}
+	return entry, nil
+}
+
+// LoadDesktopEntry reads and parses the .desktop file at path.
+func LoadDesktopEntry(path string) (DesktopEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return DesktopEntry{}, err
+	}
+	return ParseDesktopEntry(filepath.Base(path), path, data)
+}
+
+// HandlesScheme reports whether the application opens URLs with scheme.
+func (e DesktopEntry) HandlesScheme(scheme string) bool {
+	return slices.Contains(e.MimeTypes, "x-scheme-handler/"+scheme)
+}
+
+// Command splits the entry's Exec line into the program and its arguments,
+// dropping the field codes the desktop would replace with files or URLs.
+func (e DesktopEntry) Command() []string {
+	var command []string
+	for _, arg := range splitExec(e.Exec) {
+		// Field codes stand alone; flatpak wraps them in @@u ... @@.
+		if (len(arg) == 2 && arg[0] == '%' && arg != "%%") || strings.HasPrefix(arg, "@@") {
+			continue
+		}
+		command = append(command, strings.ReplaceAll(arg, "%%", "%"))
+	}
+	return command
+}
+
+// FindDesktopEntry looks up the desktop entry of the browser in config in the
+// applications directories dirs, earlier directories first. Bundle IDs and
+// app names are tried as desktop file IDs: "org.mozilla.firefox" as
+// "org.mozilla.firefox.desktop" and "Google Chrome" as
+// "google-chrome.desktop", after the entry browsers.json knows the browser
+// by. App names are then matched against the names of the browsers in dirs.
+// A path is only an entry when it names a .desktop file. Hidden entries
+// count as missing.
+func FindDesktopEntry(config BrowserConfig, dirs []string) (DesktopEntry, bool) {
+	if config.AppType == "path" {
+		if !strings.HasSuffix(config.Name, ".desktop") {
+			return DesktopEntry{}, false
+		}
+		entry, err := LoadDesktopEntry(config.Name)
+		return entry, err == nil && !entry.Hidden
+	}
+
+	var ids []string
+	if strings.HasSuffix(config.Name, ".desktop") {
+		ids = append(ids, config.Name)
+	}
+	if info, ok := findBrowserInfo(config.Name); ok && info.DesktopID != "" {
+		ids = append(ids, info.DesktopID)
+	}
+	if config.AppType == "bundleId" {
+		ids = append(ids, config.Name+".desktop")
+	} else {
+		ids = append(ids, strings.ToLower(strings.ReplaceAll(config.Name, " ", "-"))+".desktop")
+	}
+
+	for _, id := range ids {
+		for _, dir := range dirs {
+			if entry, err := LoadDesktopEntry(filepath.Join(dir, id)); err == nil {
+				if entry.Hidden {
+					break
+				}
+				return entry, true
+			}
+		}
+	}
+
+	if config.AppType == "appName" {
+		for _, entry := range FindBrowserEntries(dirs) {
+			if strings.EqualFold(entry.Name, config.Name) {
+				return entry, true
+			}
+		}
+	}
+	return DesktopEntry{}, false
+}
+
+// FindBrowserEntries returns the desktop entries in the applications
+// directories dirs that handle https URLs, sorted by ID. An entry in an
+// earlier directory hides one with the same ID in a later one, and entries
+// that are hidden or not shown in menus are left out.
+func FindBrowserEntries(dirs []string) []DesktopEntry {
+	seen := map[string]bool{}
+	var browsers []DesktopEntry
+	for _, dir := range dirs {
+		for _, entry := range readDesktopEntries(dir) {
+			if seen[entry.ID] {
+				continue
+			}
+			seen[entry.ID] = true
+			if entry.Hidden || entry.NoDisplay || !entry.HandlesScheme("https") {
+				continue
+			}
+			browsers = append(browsers, entry)
+		}
+	}
+	slices.SortFunc(browsers, func(a, b DesktopEntry) int {
+		return strings.Compare(a.ID, b.ID)
+	})
+	return browsers
+}
+
+// readDesktopEntries parses the .desktop files in an applications directory
+// and its subdirectories. Files in subdirectories get IDs prefixed with their
+// path, as in "kde4-konqueror.desktop". Files that can't be parsed are
+// skipped.
+func readDesktopEntries(dir string) []DesktopEntry {
+	var entries []DesktopEntry
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".desktop") {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			slog.Debug("Error reading desktop entry", "path", path, "error", err)
+			return nil
+		}
+		entry, err := ParseDesktopEntry(strings.ReplaceAll(filepath.ToSlash(rel), "/", "-"), path, data)
+		if err != nil {
+			slog.Debug("Error parsing desktop entry", "path", path, "error", err)
+			return nil
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	return entries
+}
+
+// desktopEntryDirs returns the applications directories in the XDG data
+// directories, most important first.
+func desktopEntryDirs() []string {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		if homeDir, err := os.UserHomeDir(); err == nil {
+			dataHome = filepath.Join(homeDir, ".local/share")
+		}
+	}
+	dataDirs := os.Getenv("XDG_DATA_DIRS")
+	if dataDirs == "" {
+		dataDirs = "/usr/local/share:/usr/share"
+	}
+
+	var dirs []string
+	for _, dir := range append([]string{dataHome}, filepath.SplitList(dataDirs)...) {
+		if dir != "" {
+			dirs = append(dirs, filepath.Join(dir, "applications"))
+		}
+	}
+	return dirs
+}
+
+// unescapeDesktopValue replaces the escape sequences of .desktop string
+// values.
+func unescapeDesktopValue(value string) string {
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+	return strings.NewReplacer(`\s`, " ", `\n`, "\n", `\t`, "\t", `\r`, "\r", `\\`, `\`).Replace(value)
+}
+
+// splitExec splits an Exec line into arguments. Arguments are separated by
+// spaces and may be double-quoted, with \", \`, \$ and \\ escaped inside the
+// quotes.
+func splitExec(exec string) []string {
+	var args []string
+	var arg strings.Builder
+	inArg, quoted := false, false
+	for i := 0; i < len(exec); i++ {
+		c := exec[i]
+		switch {
+		case quoted && c == '\\' && i+1 < len(exec):
+			i++
+			arg.WriteByte(exec[i])
+		case c == '"':
+			quoted = !quoted
+			inArg = true
+		case !quoted && (c == ' ' || c == '\t'):
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteByte(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args
+}
@@ -0,0 +1,120 @@
+package browser_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	. "finicky/browser"
+)
+
+func TestParseDesktopEntry(t *testing.T) {
+	data := []byte(`# A browser
+[Desktop Entry]
+Version=1.0
+Name=Firefox Web Browser
+Name[de]=Firefox-Webbrowser
+Exec="/opt/firefox dev/firefox" --name firefox\s%u
+Type=Application
+
+[Desktop Action new-private-window]
+Name=New Private Window
+Exec=firefox --private-window %u
+`)
+	entry, err := ParseDesktopEntry("firefox.desktop", "/usr/share/applications/firefox.desktop", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := DesktopEntry{
+		ID:   "firefox.desktop",
+		Name: "Firefox Web Browser",
+		Exec: `"/opt/firefox dev/firefox" --name firefox %u`,
+		Path: "/usr/share/applications/firefox.desktop",
+	}
+	if !reflect.DeepEqual(entry, want) {
+		t.Errorf("got %+v, want %+v", entry, want)
+	}
+	if got, want := entry.Command(), []string{"/opt/firefox dev/firefox", "--name", "firefox"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("command %q, want %q", got, want)
+	}
+
+	if _, err := ParseDesktopEntry("broken.desktop", "", []byte("Name=Broken\n")); err == nil {
+		t.Error("expected an error for a file without a [Desktop Entry] group")
+	}
+}
+
+func TestDesktopEntry_Command(t *testing.T) {
+	cases := map[string][]string{
+		"chromium %U":                           {"chromium"},
+		`env "A=b c" browser --flag=100%% %u`:   {"env", "A=b c", "browser", "--flag=100%"},
+		`sh -c "echo \"\$1\"" sh %u`:            {"sh", "-c", `echo "$1"`, "sh"},
+		"flatpak run org.app @@u %U @@ --extra": {"flatpak", "run", "org.app", "--extra"},
+	}
+	for exec, want := range cases {
+		if got := (DesktopEntry{Exec: exec}).Command(); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %q, want %q", exec, got, want)
+		}
+	}
+}
+
+func TestFindDesktopEntry(t *testing.T) {
+	local, system := t.TempDir(), t.TempDir()
+	write := func(dir string, id string, name string) {
+		content := "[Desktop Entry]\nName=" + name + "\nExec=" + name + " %u\n"
+		if err := os.WriteFile(filepath.Join(dir, id), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(system, "google-chrome.desktop", "system chrome")
+	write(local, "google-chrome.desktop", "local chrome")
+	write(system, "org.mozilla.firefox.desktop", "flatpak firefox")
+	write(system, "firefox.desktop", "firefox")
+	write(system, "some-browser.desktop", "some browser")
+	dirs := []string{local, system}
+
+	cases := []struct {
+		config BrowserConfig
+		want   string
+	}{
+		{BrowserConfig{Name: "Google Chrome", AppType: "appName"}, "local chrome"},
+		{BrowserConfig{Name: "com.google.Chrome", AppType: "bundleId"}, "local chrome"},
+		{BrowserConfig{Name: "org.mozilla.firefox", AppType: "bundleId"}, "firefox"},
+		{BrowserConfig{Name: "firefox.desktop", AppType: "appName"}, "firefox"},
+		{BrowserConfig{Name: "Some Browser", AppType: "appName"}, "some browser"},
+		{BrowserConfig{Name: filepath.Join(system, "org.mozilla.firefox.desktop"), AppType: "path"}, "flatpak firefox"},
+		{BrowserConfig{Name: "/usr/bin/firefox", AppType: "path"}, ""},
+		{BrowserConfig{Name: "Missing", AppType: "appName"}, ""},
+	}
+	for _, c := range cases {
+		entry, ok := FindDesktopEntry(c.config, dirs)
+		if ok != (c.want != "") || entry.Name != c.want {
+			t.Errorf("%s: got %q (%v), want %q", c.config.Name, entry.Name, ok, c.want)
+		}
+	}
+}
+
+func TestFindBrowserEntries(t *testing.T) {
+	dirs := []string{"testdata/xdg/local/applications", "testdata/xdg/system/applications"}
+
+	var got []string
+	for _, entry := range FindBrowserEntries(dirs) {
+		got = append(got, entry.ID+"|"+entry.Name+"|"+entry.Exec)
+	}
+	want := []string{
+		"firefox.desktop|Firefox|/usr/lib/firefox/firefox %u",
+		`google-chrome.desktop|Google Chrome (Work)|/usr/bin/google-chrome-stable --profile-directory="Profile 1" %U`,
+		"kde4-konqueror.desktop|Konqueror|kfmclient openURL %u",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+
+	entry, ok := FindDesktopEntry(BrowserConfig{Name: "Google Chrome (Work)", AppType: "appName"}, dirs)
+	if !ok || entry.ID != "google-chrome.desktop" {
+		t.Errorf("expected to find an app by its entry's name, got %+v", entry)
+	}
+	if entry, ok := FindDesktopEntry(BrowserConfig{Name: "Chromium", AppType: "appName"}, dirs); ok {
+		t.Errorf("expected the hidden entry to hide chromium, got %+v", entry)
+	}
+}
@@ -1,3 +1,5 @@
+//go:build !linux
+
 package browser
 
 /*
@@ -87,6 +89,9 @@ import (
 // GetInstalledBrowsers returns the display names of all apps registered to
 // handle https:// URLs, as reported by the macOS Launch Services framework.
 func GetInstalledBrowsers() []string {
+	if installedBrowsers != nil {
+		return installedBrowsers
+	}
 	var count C.int
 	names := C.getAllHttpsHandlerNames(&count)
 	if names == nil {
@@ -0,0 +1,29 @@
+//go:build linux
+
+package browser
+
+import "sort"
+
+// GetInstalledBrowsers returns the display names of all apps registered to
+// handle https:// URLs, as listed by the desktop entries in the XDG data
+// directories. Browsers in browsers.json are listed by their app name there,
+// as on macOS, rather than by the distribution's name for them.
+func GetInstalledBrowsers() []string {
+	if installedBrowsers != nil {
+		return installedBrowsers
+	}
+	result := []string{}
+	seen := map[string]bool{}
+	for _, entry := range FindBrowserEntries(desktopEntryDirs()) {
+		name := entry.Name
+		if info, ok := findBrowserInfo(entry.ID); ok {
+			name = info.AppName
+		}
+		if name != "" && !seen[name] {
+			seen[name] = true
+			result = append(result, name)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,26 @@
+//go:build linux
+
+package browser_test
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	. "finicky/browser"
+)
+
+func TestGetInstalledBrowsers(t *testing.T) {
+	local, err := filepath.Abs("testdata/xdg/local")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("XDG_DATA_HOME", local)
+	t.Setenv("XDG_DATA_DIRS", filepath.Join(filepath.Dir(local), "system"))
+
+	// The renamed Chrome entry is listed by the name browsers.json knows it by.
+	want := []string{"Firefox", "Google Chrome", "Konqueror"}
+	if got := GetInstalledBrowsers(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+
+package browser
+
+import (
+	"path/filepath"
+
+	"finicky/util"
+)
+
+// userHomeDir asks Foundation for the home directory, as Finicky may be
+// started without $HOME.
+var userHomeDir = util.UserHomeDir
+
+// planLaunch plans opening config's URL with macOS's open command.
+func planLaunch(config BrowserConfig, openInBackgroundByDefault bool) LaunchPlan {
+	profile := resolveBrowserProfile(config.Name, config.Profile)
+	return PlanOpen(config, openInBackgroundByDefault, profile)
+}
+
+// browserConfigDir returns the directory in homeDir a browser keeps its
+// profiles in.
+func browserConfigDir(homeDir string, info browserInfo) (string, bool) {
+	return filepath.Join(homeDir, "Library/Application Support", info.ConfigDirRelative), true
+}
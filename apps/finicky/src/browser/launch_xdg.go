@@ -0,0 +1,39 @@
+//go:build linux
+
+package browser
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+var userHomeDir = os.UserHomeDir
+
+// planLaunch plans opening config's URL through the browser's desktop entry,
+// or by running it directly.
+func planLaunch(config BrowserConfig, openInBackgroundByDefault bool) LaunchPlan {
+	if config.OpenInBackground != nil && *config.OpenInBackground {
+		slog.Debug("Opening in the background is not supported on Linux", "browser", config.Name)
+	}
+
+	var entry *DesktopEntry
+	if found, ok := FindDesktopEntry(config, desktopEntryDirs()); ok {
+		slog.Debug("Found desktop entry", "browser", config.Name, "entry", found.Path)
+		entry = &found
+	}
+	profile := resolveBrowserProfile(config.Name, config.Profile)
+	if profile == nil && entry != nil && config.Profile != "" {
+		profile = resolveBrowserProfile(entry.ID, config.Profile)
+	}
+	return PlanXDG(config, profile, entry)
+}
+
+// browserConfigDir returns the directory in homeDir a browser keeps its
+// profiles in, if browsers.json knows it.
+func browserConfigDir(homeDir string, info browserInfo) (string, bool) {
+	if info.LinuxConfigDir == "" {
+		return "", false
+	}
+	return filepath.Join(homeDir, info.LinuxConfigDir), true
+}
@@ -3,17 +3,20 @@ package browser
 import (
 	_ "embed"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 	"slices"
+	"sync"
+	"time"
 
 	"al.essio.dev/pkg/shellescape"
-	"finicky/util"
 )
 
 //go:embed browsers.json
@@ -31,6 +34,14 @@ type BrowserConfig struct {
 	Profile          string   `json:"profile"`
 	Args             []string `json:"args"`
 	URL              string   `json:"url"`
+	// Private opens the URL in a private or incognito window.
+	Private bool `json:"private"`
+	// AppWindow opens the URL in a window without browser UI. Only Chromium
+	// browsers support it.
+	AppWindow bool `json:"appWindow"`
+	// Fallbacks are tried in order when this browser isn't installed or
+	// fails to launch.
+	Fallbacks []BrowserConfig `json:"fallbacks,omitempty"`
 }
 
 type browserInfo struct {
@@ -38,74 +49,134 @@ type browserInfo struct {
 	ID                string `json:"id"`
 	AppName           string `json:"app_name"`
 	Type              string `json:"type"`
+	// DesktopID and LinuxConfigDir, relative to the home directory, are the
+	// browser's desktop entry and profiles on Linux.
+	DesktopID      string `json:"desktop_id,omitempty"`
+	LinuxConfigDir string `json:"linux_config_dir,omitempty"`
 }
 
+var installedBrowsers []string
+
+// SetInstalledBrowsers overrides the browsers GetInstalledBrowsers reports.
+// Pass nil to revert to the ones on the system. Intended for testing.
+func SetInstalledBrowsers(names []string) {
+	installedBrowsers = names
+}
+
+// LaunchBrowser opens the URL in the configured browser, or only logs the
+// command when dryRun is set. See Launch for how fallbacks are tried.
 func LaunchBrowser(config BrowserConfig, dryRun bool, openInBackgroundByDefault bool) error {
+	var launcher Launcher = ExecLauncher{}
+	if dryRun {
+		launcher = &RecordingLauncher{}
+	}
+	return Launch(launcher, config, openInBackgroundByDefault)
+}
+
+// Launch opens the URL in the configured browser with launcher. When the
+// config has fallbacks, each candidate is tried in turn: browsers from
+// browsers.json that aren't among the installed browsers are skipped, and a
+// candidate whose launch fails gives way to the next one.
+func Launch(launcher Launcher, config BrowserConfig, openInBackgroundByDefault bool) error {
 	if config.AppType == "none" {
 		slog.Info("AppType is 'none', not launching any browser")
 		return nil
 	}
+	if len(config.Fallbacks) == 0 {
+		return launchOne(launcher, config, openInBackgroundByDefault)
+	}
 
-	slog.Info("Starting browser", "name", config.Name, "url", config.URL)
-
-	var openArgs []string
+	primary := config
+	primary.Fallbacks = nil
+	candidates := append([]BrowserConfig{primary}, config.Fallbacks...)
+	installed := GetInstalledBrowsers()
 
-	if config.AppType == "bundleId" {
-		openArgs = []string{"-b", config.Name}
-	} else {
-		openArgs = []string{"-a", config.Name}
+	var errs []error
+	for i, candidate := range candidates {
+		if !isInstalled(candidate, installed) {
+			slog.Info("Skipping browser that is not installed", "name", candidate.Name)
+			errs = append(errs, fmt.Errorf("%s: not installed", candidate.Name))
+			continue
+		}
+		if err := launchOne(launcher, candidate, openInBackgroundByDefault); err != nil {
+			slog.Warn("Failed to start browser, trying the next one", "name", candidate.Name, "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", candidate.Name, err))
+			continue
+		}
+		slog.Info("Opened URL", "browser", candidate.Name, "candidate", i+1, "of", len(candidates))
+		return nil
 	}
+	return fmt.Errorf("no browser could open the URL: %w", errors.Join(errs...))
+}
 
-	var openInBackground bool = openInBackgroundByDefault
-
-	if config.OpenInBackground != nil {
-		openInBackground = *config.OpenInBackground
+// isInstalled reports whether a candidate browser is installed. The
+// installed browsers only list apps that handle https URLs, so only browsers
+// known to browsers.json can be missing from them; other apps, such as
+// editors, or an empty list are assumed to be installed and left to fail
+// launching.
+func isInstalled(config BrowserConfig, installed []string) bool {
+	if config.AppType == "none" || len(installed) == 0 {
+		return true
+	}
+	info, ok := findBrowserInfo(config.Name)
+	if !ok {
+		return true
+	}
+	for _, name := range installed {
+		if strings.EqualFold(name, config.Name) || strings.EqualFold(name, info.AppName) {
+			return true
+		}
 	}
+	return false
+}
 
-	if openInBackground {
-		openArgs = append(openArgs, "-g")
+// launchOne opens the URL in one browser, ignoring its fallbacks.
+func launchOne(launcher Launcher, config BrowserConfig, openInBackgroundByDefault bool) error {
+	if config.AppType == "none" {
+		slog.Info("AppType is 'none', not launching any browser")
+		return nil
 	}
 
-	// Handle profile and custom args
-	profileArgs, ok := resolveBrowserProfileArgs(config.Name, config.Profile)
-	hasCustomArgs := len(config.Args) > 0
+	slog.Info("Starting browser", "name", config.Name, "url", config.URL)
 
-	// Add -n flag if profile is used (required for profile switching)
-	if ok {
-		openArgs = append(openArgs, "-n")
-	}
+	return launcher.Launch(planLaunch(config, openInBackgroundByDefault))
+}
 
-	// Add --args if we have profile args or custom args
-	if ok || hasCustomArgs {
-		if ! slices.Contains(config.Args, "--args") {
-			openArgs = append(openArgs, "--args")
-		}
-		// Add profile arguments first if present
-		if ok {
-			openArgs = append(openArgs, profileArgs...)
-		}
+// Launcher runs launch plans.
+type Launcher interface {
+	Launch(plan LaunchPlan) error
+}
 
-		// Add custom args or URL
-		if hasCustomArgs {
-			openArgs = append(openArgs, config.Args...)
-		} else {
-			openArgs = append(openArgs, config.URL)
-		}
-	} else {
-		// No special args, just add the URL
-		openArgs = append(openArgs, config.URL)
-	}
+// ExecLauncher runs launch plans as commands, failing when a command exits
+// with an error. A detached command only fails when it exits with an error
+// within DetachGracePeriod.
+type ExecLauncher struct{}
 
-	cmd := exec.Command("open", openArgs...)
+// DetachGracePeriod is how long a detached command is watched for exiting
+// with an error. A browser that can't start, such as one with a bad profile
+// or flag, exits at once; one that runs past this, or exits cleanly after
+// handing the URL to a running instance, has opened it.
+var DetachGracePeriod = 500 * time.Millisecond
 
-	// Pretty print the command with proper escaping
-	prettyCmd := formatCommand(cmd.Path, cmd.Args)
+func (ExecLauncher) Launch(plan LaunchPlan) error {
+	cmd := exec.Command(plan.Command, plan.Args...)
 
-	if dryRun {
-		slog.Debug("Would run command (dry run)", "command", prettyCmd)
+	slog.Debug("Run command", "command", plan.String())
+
+	if plan.Detach {
+		if err := cmd.Start(); err != nil {
+			return err
+		}
+		exited := make(chan error, 1)
+		go func() { exited <- cmd.Wait() }()
+		select {
+		case err := <-exited:
+			if err != nil {
+				return fmt.Errorf("command failed: %v", err)
+			}
+		case <-time.After(DetachGracePeriod):
+		}
 		return nil
-	} else {
-		slog.Debug("Run command", "command", prettyCmd)
 	}
 
 	stderr, err := cmd.StderrPipe()
@@ -147,24 +218,76 @@ func LaunchBrowser(config BrowserConfig, dryRun bool, openInBackgroundByDefault
 	return nil
 }
 
-func resolveBrowserProfileArgs(identifier string, profile string) ([]string, bool) {
-	var browsersJson []browserInfo
-	if err := json.Unmarshal(browsersJsonData, &browsersJson); err != nil {
-		slog.Info("Error parsing browsers.json", "error", err)
-		return nil, false
+// RecordingLauncher records launch plans instead of running them. It is used
+// for dry runs and in tests.
+type RecordingLauncher struct {
+	// Fail, when set, returns the error launching plan should fail with, or
+	// nil for it to succeed.
+	Fail func(plan LaunchPlan) error
+
+	mu    sync.Mutex
+	plans []LaunchPlan
+}
+
+func (l *RecordingLauncher) Launch(plan LaunchPlan) error {
+	slog.Debug("Would run command (dry run)", "command", plan.String())
+	l.mu.Lock()
+	l.plans = append(l.plans, plan)
+	l.mu.Unlock()
+	if l.Fail != nil {
+		return l.Fail(plan)
 	}
+	return nil
+}
 
-	// Try to find matching browser by bundle ID
-	var matchedBrowser *browserInfo
-	for _, browser := range browsersJson {
-		if browser.ID == identifier || browser.AppName == identifier {
-			matchedBrowser = &browser
-			break
+// Plans returns the plans launched so far, in order.
+func (l *RecordingLauncher) Plans() []LaunchPlan {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return slices.Clone(l.plans)
+}
+
+// expandArgPlaceholders replaces placeholders in custom args: {url}, {host},
+// {path} and {query} with parts of rawURL, and {profileDir} with the
+// directory of the browser profile. It also reports whether the args place
+// the URL, with a URL placeholder or an argument that contains the URL, such
+// as "--app=https://example.com".
+func expandArgPlaceholders(args []string, rawURL string, profileDir string) ([]string, bool) {
+	var host, path, query string
+	if u, err := url.Parse(rawURL); err == nil {
+		host, path, query = u.Host, u.EscapedPath(), u.RawQuery
+	}
+	replacer := strings.NewReplacer(
+		"{url}", rawURL,
+		"{host}", host,
+		"{path}", path,
+		"{query}", query,
+		"{profileDir}", profileDir,
+	)
+
+	expanded := make([]string, 0, len(args))
+	placed := false
+	for _, arg := range args {
+		if rawURL != "" && strings.Contains(arg, rawURL) {
+			placed = true
+		}
+		for _, placeholder := range []string{"{url}", "{host}", "{path}", "{query}"} {
+			if strings.Contains(arg, placeholder) {
+				placed = true
+			}
 		}
+		expanded = append(expanded, replacer.Replace(arg))
 	}
+	return expanded, placed
+}
 
-	if matchedBrowser == nil {
-		return nil, false
+// resolveBrowserProfile finds the named profile of a browser on disk. It
+// returns nil when the browser has no such profile, or profiles aren't
+// supported for it.
+func resolveBrowserProfile(identifier string, profile string) *Profile {
+	matchedBrowser, ok := findBrowserInfo(identifier)
+	if !ok {
+		return nil
 	}
 
 	slog.Debug("Browser found in browsers.json", "identifier", identifier, "type", matchedBrowser.Type)
@@ -172,35 +295,95 @@ func resolveBrowserProfileArgs(identifier string, profile string) ([]string, boo
 	if profile != "" {
 		switch matchedBrowser.Type {
 		case "Chromium":
-			homeDir, err := util.UserHomeDir()
-			if err != nil {
-				slog.Info("Error getting home directory", "error", err)
-				return nil, false
+			configDir, ok := profilesDir(matchedBrowser)
+			if !ok {
+				return nil
 			}
-
-			localStatePath := filepath.Join(homeDir, "Library/Application Support", matchedBrowser.ConfigDirRelative, "Local State")
+			localStatePath := filepath.Join(configDir, "Local State")
 			profilePath, ok := parseProfiles(localStatePath, profile)
 			if ok {
-				return []string{"--profile-directory=" + profilePath}, true
+				return &Profile{Args: []string{"--profile-directory=" + profilePath}, Dir: filepath.Join(configDir, profilePath)}
 			}
 		case "Firefox":
-			homeDir, err := util.UserHomeDir()
-			if err != nil {
-				slog.Info("Error getting home directory", "error", err)
-				return nil, false
+			configDir, ok := profilesDir(matchedBrowser)
+			if !ok {
+				return nil
 			}
-
-			profilesIniPath := filepath.Join(homeDir, "Library/Application Support", matchedBrowser.ConfigDirRelative, "profiles.ini")
+			profilesIniPath := filepath.Join(configDir, "profiles.ini")
 			profileName, ok := parseFirefoxProfiles(profilesIniPath, profile)
 			if ok {
-				return []string{"-P", profileName}, true
+				return &Profile{Args: []string{"-P", profileName}, Dir: firefoxProfileDir(profilesIniPath, profileName)}
 			}
 		default:
 			slog.Info("Browser is not a supported browser type, skipping profile detection", "identifier", identifier)
 		}
 	}
 
-	return nil, false
+	return nil
+}
+
+// profilesDir returns the directory a browser keeps its profiles in.
+func profilesDir(info browserInfo) (string, bool) {
+	homeDir, err := userHomeDir()
+	if err != nil {
+		slog.Info("Error getting home directory", "error", err)
+		return "", false
+	}
+	return browserConfigDir(homeDir, info)
+}
+
+// resolveLaunchModeArgs returns the arguments that open the URL in a private
+// or app window in the browser's family, and whether they include the URL.
+// Modes the browser doesn't support are skipped with a warning.
+func resolveLaunchModeArgs(config BrowserConfig) ([]string, bool) {
+	if !config.Private && !config.AppWindow {
+		return nil, false
+	}
+
+	browserType := ""
+	if info, ok := findBrowserInfo(config.Name); ok {
+		browserType = info.Type
+	}
+
+	var args []string
+	hasURL := false
+	switch browserType {
+	case "Chromium":
+		if config.Private {
+			args = append(args, "--incognito")
+		}
+		if config.AppWindow {
+			args = append(args, "--app="+config.URL)
+			hasURL = true
+		}
+	case "Firefox":
+		if config.Private {
+			args = append(args, "-private-window", config.URL)
+			hasURL = true
+		}
+		if config.AppWindow {
+			slog.Warn("Firefox does not support app windows, opening a normal window", "browser", config.Name)
+		}
+	default:
+		slog.Warn("Browser does not support private or app windows, opening a normal window", "browser", config.Name)
+	}
+	return args, hasURL
+}
+
+// findBrowserInfo looks up a browser in browsers.json by bundle ID, app name
+// or desktop file ID.
+func findBrowserInfo(identifier string) (browserInfo, bool) {
+	var browsersJson []browserInfo
+	if err := json.Unmarshal(browsersJsonData, &browsersJson); err != nil {
+		slog.Info("Error parsing browsers.json", "error", err)
+		return browserInfo{}, false
+	}
+	for _, browser := range browsersJson {
+		if browser.ID == identifier || browser.AppName == identifier || (browser.DesktopID != "" && browser.DesktopID == identifier) {
+			return browser, true
+		}
+	}
+	return browserInfo{}, false
 }
 
 func readFirefoxProfileNames(profilesIniPath string) []string {
@@ -220,6 +403,44 @@ func readFirefoxProfileNames(profilesIniPath string) []string {
 	return names
 }
 
+// firefoxProfileDir returns the directory of the named profile in
+// profiles.ini, or "" when it can't be found.
+func firefoxProfileDir(profilesIniPath string, profile string) string {
+	data, err := os.ReadFile(profilesIniPath)
+	if err != nil {
+		slog.Info("Error reading profiles.ini", "path", profilesIniPath, "error", err)
+		return ""
+	}
+
+	var name, path string
+	relative := true
+	dir := func() string {
+		if name != profile || path == "" {
+			return ""
+		}
+		if relative {
+			return filepath.Join(filepath.Dir(profilesIniPath), path)
+		}
+		return path
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			if d := dir(); d != "" {
+				return d
+			}
+			name, path, relative = "", "", true
+		} else if value, ok := strings.CutPrefix(line, "Name="); ok {
+			name = value
+		} else if value, ok := strings.CutPrefix(line, "Path="); ok {
+			path = value
+		} else if value, ok := strings.CutPrefix(line, "IsRelative="); ok {
+			relative = value != "0"
+		}
+	}
+	return dir()
+}
+
 func parseFirefoxProfiles(profilesIniPath string, profile string) (string, bool) {
 	names := readFirefoxProfileNames(profilesIniPath)
 	for _, name := range names {
@@ -341,37 +562,24 @@ func parseProfiles(localStatePath string, profile string) (string, bool) {
 // GetProfilesForBrowser returns available profile names for a given browser app name or bundle ID.
 // Returns empty slice if browser not in browsers.json, not supported, or profile files are unreadable.
 func GetProfilesForBrowser(identifier string) []string {
-	var browsersJson []browserInfo
-	if err := json.Unmarshal(browsersJsonData, &browsersJson); err != nil {
-		slog.Info("Error parsing browsers.json", "error", err)
-		return []string{}
-	}
-
-	var matchedBrowser *browserInfo
-	for i := range browsersJson {
-		if browsersJson[i].ID == identifier || browsersJson[i].AppName == identifier {
-			matchedBrowser = &browsersJson[i]
-			break
-		}
-	}
-
-	if matchedBrowser == nil {
-		return []string{}
-	}
-
-	homeDir, err := util.UserHomeDir()
-	if err != nil {
-		slog.Info("Error getting home directory", "error", err)
+	matchedBrowser, ok := findBrowserInfo(identifier)
+	if !ok {
 		return []string{}
 	}
 
 	switch matchedBrowser.Type {
 	case "Chromium":
-		localStatePath := filepath.Join(homeDir, "Library/Application Support", matchedBrowser.ConfigDirRelative, "Local State")
-		return getAllChromiumProfiles(localStatePath)
+		configDir, ok := profilesDir(matchedBrowser)
+		if !ok {
+			return []string{}
+		}
+		return getAllChromiumProfiles(filepath.Join(configDir, "Local State"))
 	case "Firefox":
-		profilesIniPath := filepath.Join(homeDir, "Library/Application Support", matchedBrowser.ConfigDirRelative, "profiles.ini")
-		return readFirefoxProfileNames(profilesIniPath)
+		configDir, ok := profilesDir(matchedBrowser)
+		if !ok {
+			return []string{}
+		}
+		return readFirefoxProfileNames(filepath.Join(configDir, "profiles.ini"))
 	default:
 		return []string{}
 	}
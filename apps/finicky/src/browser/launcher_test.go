@@ -0,0 +1,472 @@
+package browser_test
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	. "finicky/browser"
+)
+
+func TestPlanOpen(t *testing.T) {
+	yes, no := true, false
+	chromeProfile := &Profile{Args: []string{"--profile-directory=Profile 1"}, Dir: "/chrome/Profile 1"}
+	firefoxProfile := &Profile{Args: []string{"-P", "Work"}, Dir: "/firefox/work"}
+	const url = "https://example.com/a?b=c"
+
+	cases := []struct {
+		name       string
+		config     BrowserConfig
+		background bool
+		profile    *Profile
+		want       []string
+	}{
+		{
+			"app name",
+			BrowserConfig{Name: "Safari", AppType: "appName", URL: url},
+			false, nil,
+			[]string{"-a", "Safari", url},
+		},
+		{
+			"bundle id in background by default",
+			BrowserConfig{Name: "com.apple.Safari", AppType: "bundleId", URL: url},
+			true, nil,
+			[]string{"-b", "com.apple.Safari", "-g", url},
+		},
+		{
+			"config overrides background",
+			BrowserConfig{Name: "Safari", AppType: "appName", OpenInBackground: &no, URL: url},
+			true, nil,
+			[]string{"-a", "Safari", url},
+		},
+		{
+			"config asks for background",
+			BrowserConfig{Name: "Safari", AppType: "appName", OpenInBackground: &yes, URL: url},
+			false, nil,
+			[]string{"-a", "Safari", "-g", url},
+		},
+		{
+			"chromium profile",
+			BrowserConfig{Name: "Google Chrome", AppType: "appName", Profile: "Work", URL: url},
+			false, chromeProfile,
+			[]string{"-a", "Google Chrome", "-n", "--args", "--profile-directory=Profile 1", url},
+		},
+		{
+			"chromium profile in background",
+			BrowserConfig{Name: "Google Chrome", AppType: "appName", Profile: "Work", URL: url},
+			true, chromeProfile,
+			[]string{"-a", "Google Chrome", "-g", "-n", "--args", "--profile-directory=Profile 1", url},
+		},
+		{
+			"firefox profile",
+			BrowserConfig{Name: "org.mozilla.firefox", AppType: "bundleId", Profile: "Work", URL: url},
+			false, firefoxProfile,
+			[]string{"-b", "org.mozilla.firefox", "-n", "--args", "-P", "Work", url},
+		},
+		{
+			"custom args get the url appended",
+			BrowserConfig{Name: "Google Chrome", AppType: "appName", Args: []string{"--new-window"}, URL: url},
+			false, nil,
+			[]string{"-a", "Google Chrome", "--args", "--new-window", url},
+		},
+		{
+			"custom args with placeholders",
+			BrowserConfig{Name: "Visual Studio Code", AppType: "appName", Args: []string{"--goto", "{host}{path}?{query}", "--user-data-dir={profileDir}"}, URL: url},
+			false, chromeProfile,
+			[]string{"-a", "Visual Studio Code", "-n", "--args", "--profile-directory=Profile 1", "--goto", "example.com/a?b=c", "--user-data-dir=/chrome/Profile 1"},
+		},
+		{
+			"custom args with the url",
+			BrowserConfig{Name: "Google Chrome", AppType: "appName", Args: []string{"--args", url, "--new-window"}, URL: url},
+			false, nil,
+			[]string{"-a", "Google Chrome", "--args", url, "--new-window"},
+		},
+		{
+			"chromium private app window",
+			BrowserConfig{Name: "Google Chrome", AppType: "appName", Private: true, AppWindow: true, URL: url},
+			false, chromeProfile,
+			[]string{"-a", "Google Chrome", "-n", "--args", "--profile-directory=Profile 1", "--incognito", "--app=" + url},
+		},
+		{
+			"firefox private window",
+			BrowserConfig{Name: "Firefox", AppType: "appName", Private: true, URL: url},
+			false, nil,
+			[]string{"-a", "Firefox", "-n", "--args", "-private-window", url},
+		},
+		{
+			"private window is ignored by safari",
+			BrowserConfig{Name: "Safari", AppType: "appName", Private: true, URL: url},
+			false, nil,
+			[]string{"-a", "Safari", url},
+		},
+	}
+	for _, c := range cases {
+		plan := PlanOpen(c.config, c.background, c.profile)
+		if plan.Command != "open" || !reflect.DeepEqual(plan.Args, c.want) {
+			t.Errorf("%s:\n  got  %s %q\n  want open %q", c.name, plan.Command, plan.Args, c.want)
+		}
+	}
+}
+
+func TestPlanOpen_ArgPlaceholders(t *testing.T) {
+	const url = "https://example.com/a/b?c=d&e=f"
+
+	cases := []struct {
+		name    string
+		args    []string
+		profile *Profile
+		want    []string
+	}{
+		{"no placeholder appends the url", []string{"--new-window"}, nil, []string{"--new-window", url}},
+		{"{url}", []string{"--open", "{url}"}, nil, []string{"--open", url}},
+		{"{url} inside an arg", []string{"--app={url}"}, nil, []string{"--app=" + url}},
+		{"{host}", []string{"{host}"}, nil, []string{"example.com"}},
+		{"{path}", []string{"--path={path}"}, nil, []string{"--path=/a/b"}},
+		{"{query}", []string{"--query={query}"}, nil, []string{"--query=c=d&e=f"}},
+		{"the url", []string{url, "--new-window"}, nil, []string{url, "--new-window"}},
+		{"an arg containing the url", []string{"--app=" + url}, nil, []string{"--app=" + url}},
+		{
+			"{profileDir} doesn't place the url",
+			[]string{"--user-data-dir={profileDir}"},
+			&Profile{Dir: "/profiles/work"},
+			[]string{"--user-data-dir=/profiles/work", url},
+		},
+	}
+	for _, c := range cases {
+		config := BrowserConfig{Name: "Visual Studio Code", AppType: "appName", Args: c.args, URL: url}
+		plan := PlanOpen(config, false, c.profile)
+		want := []string{"-a", "Visual Studio Code"}
+		if c.profile != nil {
+			want = append(want, "-n")
+		}
+		want = append(append(want, "--args"), c.want...)
+		if !reflect.DeepEqual(plan.Args, want) {
+			t.Errorf("%s:\n  got  %q\n  want %q", c.name, plan.Args, want)
+		}
+	}
+}
+
+func TestPlanOpen_LaunchModes(t *testing.T) {
+	const url = "https://example.com/a?b=c"
+	const unsupported = "does not support private or app windows"
+
+	cases := []struct {
+		name   string
+		config BrowserConfig
+		want   []string
+		warn   string
+	}{
+		{
+			"chromium private window",
+			BrowserConfig{Name: "Google Chrome", AppType: "appName", Private: true, URL: url},
+			[]string{"-a", "Google Chrome", "-n", "--args", "--incognito", url}, "",
+		},
+		{
+			"chromium app window",
+			BrowserConfig{Name: "com.brave.Browser", AppType: "bundleId", AppWindow: true, URL: url},
+			[]string{"-b", "com.brave.Browser", "-n", "--args", "--app=" + url}, "",
+		},
+		{
+			"firefox private window",
+			BrowserConfig{Name: "org.mozilla.firefox", AppType: "bundleId", Private: true, URL: url},
+			[]string{"-b", "org.mozilla.firefox", "-n", "--args", "-private-window", url}, "",
+		},
+		{
+			"firefox app window",
+			BrowserConfig{Name: "Firefox", AppType: "appName", AppWindow: true, URL: url},
+			[]string{"-a", "Firefox", url}, "Firefox does not support app windows",
+		},
+		{
+			"unsupported family",
+			BrowserConfig{Name: "Safari", AppType: "appName", Private: true, AppWindow: true, URL: url},
+			[]string{"-a", "Safari", url}, unsupported,
+		},
+		{
+			"unknown browser",
+			BrowserConfig{Name: "/Applications/Browser.app", AppType: "path", Private: true, URL: url},
+			[]string{"-a", "/Applications/Browser.app", url}, unsupported,
+		},
+		{
+			"custom args come after the mode and get the url",
+			BrowserConfig{Name: "Google Chrome", AppType: "appName", Private: true, Args: []string{"--new-window"}, URL: url},
+			[]string{"-a", "Google Chrome", "-n", "--args", "--incognito", "--new-window", url}, "",
+		},
+		{
+			"app window places the url for custom args",
+			BrowserConfig{Name: "Google Chrome", AppType: "appName", AppWindow: true, Args: []string{"--new-window"}, URL: url},
+			[]string{"-a", "Google Chrome", "-n", "--args", "--app=" + url, "--new-window"}, "",
+		},
+		{
+			"private window places the url for custom args",
+			BrowserConfig{Name: "Firefox", AppType: "appName", Private: true, Args: []string{"-no-remote"}, URL: url},
+			[]string{"-a", "Firefox", "-n", "--args", "-private-window", url, "-no-remote"}, "",
+		},
+		{
+			"unsupported family keeps custom args",
+			BrowserConfig{Name: "Safari", AppType: "appName", Private: true, Args: []string{"--flag"}, URL: url},
+			[]string{"-a", "Safari", "--args", "--flag", url}, unsupported,
+		},
+	}
+
+	defaultLogger := slog.Default()
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+	for _, c := range cases {
+		var logs bytes.Buffer
+		slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+
+		plan := PlanOpen(c.config, false, nil)
+		if !reflect.DeepEqual(plan.Args, c.want) {
+			t.Errorf("%s:\n  got  %q\n  want %q", c.name, plan.Args, c.want)
+		}
+		if c.warn == "" && strings.Contains(logs.String(), "level=WARN") {
+			t.Errorf("%s: unexpected warning: %s", c.name, logs.String())
+		}
+		if c.warn != "" && !strings.Contains(logs.String(), c.warn) {
+			t.Errorf("%s: expected a warning containing %q, got %q", c.name, c.warn, logs.String())
+		}
+	}
+}
+
+func TestPlanXDG(t *testing.T) {
+	chromeProfile := &Profile{Args: []string{"--profile-directory=Profile 1"}, Dir: "/chrome/Profile 1"}
+	firefoxProfile := &Profile{Args: []string{"-P", "Work"}, Dir: "/firefox/work"}
+	chrome := &DesktopEntry{ID: "google-chrome.desktop", Exec: "/usr/bin/google-chrome-stable %U", Path: "/usr/share/applications/google-chrome.desktop"}
+	firefox := &DesktopEntry{ID: "org.mozilla.firefox.desktop", Exec: "/usr/bin/flatpak run --branch=stable --command=firefox --file-forwarding org.mozilla.firefox @@u %u @@", Path: "/var/lib/flatpak/exports/share/applications/org.mozilla.firefox.desktop"}
+	const url = "https://example.com/a?b=c"
+
+	cases := []struct {
+		name    string
+		config  BrowserConfig
+		profile *Profile
+		entry   *DesktopEntry
+		want    []string
+	}{
+		{
+			"desktop entry",
+			BrowserConfig{Name: "Google Chrome", AppType: "appName", URL: url},
+			nil, chrome,
+			[]string{"gio", "launch", "/usr/share/applications/google-chrome.desktop", url},
+		},
+		{
+			"profile runs the entry's command",
+			BrowserConfig{Name: "Google Chrome", AppType: "appName", Profile: "Work", URL: url},
+			chromeProfile, chrome,
+			[]string{"/usr/bin/google-chrome-stable", "--profile-directory=Profile 1", url},
+		},
+		{
+			"chromium private app window",
+			BrowserConfig{Name: "google-chrome.desktop", AppType: "appName", Private: true, AppWindow: true, URL: url},
+			nil, chrome,
+			[]string{"/usr/bin/google-chrome-stable", "--incognito", "--app=" + url},
+		},
+		{
+			"flatpak firefox profile in a private window",
+			BrowserConfig{Name: "org.mozilla.firefox", AppType: "bundleId", Profile: "Work", Private: true, URL: url},
+			firefoxProfile, firefox,
+			[]string{"/usr/bin/flatpak", "run", "--branch=stable", "--command=firefox", "--file-forwarding", "org.mozilla.firefox", "-P", "Work", "-private-window", url},
+		},
+		{
+			"path to a binary",
+			BrowserConfig{Name: "/opt/firefox/firefox", AppType: "path", Private: true, URL: url},
+			nil, nil,
+			[]string{"/opt/firefox/firefox", url},
+		},
+		{
+			"path to a desktop entry names the browser family",
+			BrowserConfig{Name: "/usr/share/applications/google-chrome.desktop", AppType: "path", Private: true, URL: url},
+			nil, chrome,
+			[]string{"/usr/bin/google-chrome-stable", "--incognito", url},
+		},
+		{
+			"custom args drop open's args",
+			BrowserConfig{Name: "Google Chrome", AppType: "appName", Args: []string{"-F", "--args", "--new-window", "{url}"}, URL: url},
+			nil, chrome,
+			[]string{"/usr/bin/google-chrome-stable", "--new-window", url},
+		},
+		{
+			"custom args with placeholders",
+			BrowserConfig{Name: "Google Chrome", AppType: "appName", Args: []string{"--user-data-dir={profileDir}"}, URL: url},
+			chromeProfile, chrome,
+			[]string{"/usr/bin/google-chrome-stable", "--profile-directory=Profile 1", "--user-data-dir=/chrome/Profile 1", url},
+		},
+	}
+	for _, c := range cases {
+		plan := PlanXDG(c.config, c.profile, c.entry)
+		got := append([]string{plan.Command}, plan.Args...)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s:\n  got  %q\n  want %q", c.name, got, c.want)
+		}
+		if wantDetach := plan.Command != "gio"; plan.Detach != wantDetach {
+			t.Errorf("%s: detach = %v, want %v", c.name, plan.Detach, wantDetach)
+		}
+	}
+}
+
+func TestLaunch_Fallbacks(t *testing.T) {
+	const url = "https://example.com/"
+	config := BrowserConfig{
+		Name: "company.browser.missing", AppType: "bundleId", URL: url,
+		Fallbacks: []BrowserConfig{
+			{Name: "com.google.Chrome", AppType: "bundleId", URL: url},
+			{Name: "com.apple.Safari", AppType: "bundleId", URL: url},
+		},
+	}
+
+	launcher := &RecordingLauncher{Fail: func(plan LaunchPlan) error {
+		if plan.Browser == "company.browser.missing" {
+			return errors.New("command failed: exit status 1")
+		}
+		return nil
+	}}
+	if err := Launch(launcher, config, false); err != nil {
+		t.Fatal(err)
+	}
+	var tried []string
+	for _, plan := range launcher.Plans() {
+		tried = append(tried, plan.Browser)
+	}
+	if want := []string{"company.browser.missing", "com.google.Chrome"}; !reflect.DeepEqual(tried, want) {
+		t.Errorf("tried %v, want %v", tried, want)
+	}
+
+	failing := &RecordingLauncher{Fail: func(LaunchPlan) error { return errors.New("command failed") }}
+	if err := Launch(failing, config, false); err == nil {
+		t.Error("expected an error when every browser fails")
+	}
+	if n := len(failing.Plans()); n != 3 {
+		t.Errorf("expected all 3 browsers to be tried, got %d", n)
+	}
+
+	none := &RecordingLauncher{}
+	if err := Launch(none, BrowserConfig{AppType: "none", URL: url}, false); err != nil || len(none.Plans()) != 0 {
+		t.Errorf("expected nothing to launch for appType none, got %v %v", none.Plans(), err)
+	}
+}
+
+func TestExecLauncher_Detach(t *testing.T) {
+	gracePeriod := DetachGracePeriod
+	DetachGracePeriod = 200 * time.Millisecond
+	t.Cleanup(func() { DetachGracePeriod = gracePeriod })
+
+	cases := []struct {
+		name    string
+		script  string
+		wantErr bool
+	}{
+		{"exits with an error", "exit 3", true},
+		{"exits cleanly", "exit 0", false},
+		{"keeps running", "sleep 2", false},
+		{"fails after the grace period", "sleep 1; exit 3", false},
+	}
+	for _, c := range cases {
+		plan := LaunchPlan{Browser: "sh", Command: "sh", Args: []string{"-c", c.script}, Detach: true}
+		start := time.Now()
+		err := ExecLauncher{}.Launch(plan)
+		if (err != nil) != c.wantErr {
+			t.Errorf("%s: got error %v, want error %v", c.name, err, c.wantErr)
+		}
+		if elapsed := time.Since(start); elapsed > 900*time.Millisecond {
+			t.Errorf("%s: waited %v for a detached command", c.name, elapsed)
+		}
+	}
+
+	if err := (ExecLauncher{}).Launch(LaunchPlan{Command: "finicky-missing-browser", Detach: true}); err == nil {
+		t.Error("expected an error for a command that can't be started")
+	}
+}
+
+func TestLaunch_FallbackCandidates(t *testing.T) {
+	SetInstalledBrowsers([]string{"Firefox", "Safari"})
+	t.Cleanup(func() { SetInstalledBrowsers(nil) })
+
+	const url = "https://example.com/"
+	exitErr := errors.New("command failed: exit status 1")
+	candidate := func(name, appType string) BrowserConfig {
+		return BrowserConfig{Name: name, AppType: appType, URL: url}
+	}
+	withFallbacks := func(primary BrowserConfig, fallbacks ...BrowserConfig) BrowserConfig {
+		primary.Fallbacks = fallbacks
+		return primary
+	}
+
+	cases := []struct {
+		name    string
+		config  BrowserConfig
+		failing []string
+		tried   []string
+		errs    []string
+	}{
+		{
+			"skip missing",
+			withFallbacks(candidate("Google Chrome", "appName"), candidate("com.microsoft.edgemac", "bundleId"), candidate("Firefox", "appName")),
+			nil,
+			[]string{"Firefox"},
+			nil,
+		},
+		{
+			"installed browsers match case-insensitively",
+			withFallbacks(candidate("firefox", "appName"), candidate("Safari", "appName")),
+			[]string{"firefox"},
+			[]string{"firefox", "Safari"},
+			nil,
+		},
+		{
+			"apps that aren't browsers aren't skipped",
+			withFallbacks(candidate("Visual Studio Code", "appName"), candidate("/usr/local/bin/editor", "path"), candidate("com.apple.Safari", "bundleId")),
+			[]string{"Visual Studio Code"},
+			[]string{"Visual Studio Code", "/usr/local/bin/editor"},
+			nil,
+		},
+		{
+			"advance on non-zero exit",
+			withFallbacks(candidate("Firefox", "appName"), candidate("Safari", "appName")),
+			[]string{"Firefox"},
+			[]string{"Firefox", "Safari"},
+			nil,
+		},
+		{
+			"all fail",
+			withFallbacks(candidate("Firefox", "appName"), candidate("Google Chrome", "appName"), candidate("Safari", "appName")),
+			[]string{"Firefox", "Safari"},
+			[]string{"Firefox", "Safari"},
+			[]string{"Firefox: " + exitErr.Error(), "Google Chrome: not installed", "Safari: " + exitErr.Error()},
+		},
+	}
+	for _, c := range cases {
+		launcher := &RecordingLauncher{Fail: func(plan LaunchPlan) error {
+			if slices.Contains(c.failing, plan.Browser) {
+				return exitErr
+			}
+			return nil
+		}}
+		err := Launch(launcher, c.config, false)
+
+		var tried []string
+		for _, plan := range launcher.Plans() {
+			tried = append(tried, plan.Browser)
+		}
+		if !reflect.DeepEqual(tried, c.tried) {
+			t.Errorf("%s: tried %v, want %v", c.name, tried, c.tried)
+		}
+		if c.errs == nil {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", c.name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, exitErr) {
+			t.Errorf("%s: expected the launch error to be joined, got %v", c.name, err)
+			continue
+		}
+		for _, want := range c.errs {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: error %q doesn't contain %q", c.name, err, want)
+			}
+		}
+	}
+}
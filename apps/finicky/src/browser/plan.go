@@ -0,0 +1,142 @@
+package browser
+
+import (
+	"slices"
+)
+
+// LaunchPlan is the command that opens a URL in a browser.
+type LaunchPlan struct {
+	// Browser is the name of the browser the command opens.
+	Browser string   `json:"browser"`
+	Command string   `json:"command"`
+	Args    []string `json:"args"`
+	// Detach is set when the command is the browser itself, which keeps
+	// running after opening the URL, so it mustn't be waited for. Only an
+	// error exit shortly after starting counts as a failed launch.
+	Detach bool `json:"detach,omitempty"`
+}
+
+// String returns the command shell-escaped, for logs.
+func (p LaunchPlan) String() string {
+	return formatCommand(p.Command, append([]string{p.Command}, p.Args...))
+}
+
+// Profile is a browser profile found on disk.
+type Profile struct {
+	// Args select the profile on the browser's command line.
+	Args []string
+	// Dir is the profile's directory, which {profileDir} in args expands to.
+	Dir string
+}
+
+// PlanOpen returns the macOS open command that opens config's URL, ignoring
+// its fallbacks. profile is the browser's profile, or nil when no profile was
+// found. It doesn't read the disk or run anything, so the same config always
+// gives the same plan.
+func PlanOpen(config BrowserConfig, openInBackgroundByDefault bool, profile *Profile) LaunchPlan {
+	var openArgs []string
+
+	if config.AppType == "bundleId" {
+		openArgs = []string{"-b", config.Name}
+	} else {
+		openArgs = []string{"-a", config.Name}
+	}
+
+	var openInBackground bool = openInBackgroundByDefault
+
+	if config.OpenInBackground != nil {
+		openInBackground = *config.OpenInBackground
+	}
+
+	if openInBackground {
+		openArgs = append(openArgs, "-g")
+	}
+
+	// Handle profile, launch mode and custom args
+	modeArgs, modeHasURL := resolveLaunchModeArgs(config)
+	hasCustomArgs := len(config.Args) > 0
+	profileDir := ""
+	if profile != nil {
+		profileDir = profile.Dir
+	}
+
+	// Add -n flag if profile or launch mode is used, as a running browser
+	// ignores the arguments otherwise
+	if profile != nil || len(modeArgs) > 0 {
+		openArgs = append(openArgs, "-n")
+	}
+
+	// Add --args if we have profile args, launch mode args or custom args
+	if profile != nil || len(modeArgs) > 0 || hasCustomArgs {
+		if !slices.Contains(config.Args, "--args") {
+			openArgs = append(openArgs, "--args")
+		}
+		// Add profile arguments first if present
+		if profile != nil {
+			openArgs = append(openArgs, profile.Args...)
+		}
+		openArgs = append(openArgs, modeArgs...)
+
+		// Add custom args, then the URL unless they or the launch mode args
+		// placed it
+		customArgs, placed := expandArgPlaceholders(config.Args, config.URL, profileDir)
+		openArgs = append(openArgs, customArgs...)
+		if !placed && !modeHasURL {
+			openArgs = append(openArgs, config.URL)
+		}
+	} else {
+		// No special args, just add the URL
+		openArgs = append(openArgs, config.URL)
+	}
+
+	return LaunchPlan{Browser: config.Name, Command: "open", Args: openArgs}
+}
+
+// PlanXDG returns the command that opens config's URL on a Linux desktop,
+// ignoring its fallbacks. entry is the browser's desktop entry, or nil when
+// none was found. A browser opened without extra arguments is started through
+// its desktop entry with gio launch; one that needs profile, launch mode or
+// custom args runs the entry's command directly, as desktop entries can't pass
+// them on. Without an entry, config's name is run as the command. xdg-open
+// isn't used, as it only opens the default browser, which is Finicky.
+// Like PlanOpen, it doesn't read the disk or run anything.
+func PlanXDG(config BrowserConfig, profile *Profile, entry *DesktopEntry) LaunchPlan {
+	// The browser family decides the launch mode args; the entry may know it
+	// when the config names a path
+	modeConfig := config
+	if _, ok := findBrowserInfo(config.Name); !ok && entry != nil {
+		modeConfig.Name = entry.ID
+	}
+	modeArgs, modeHasURL := resolveLaunchModeArgs(modeConfig)
+	if entry != nil && profile == nil && len(modeArgs) == 0 && len(config.Args) == 0 {
+		return LaunchPlan{Browser: config.Name, Command: "gio", Args: []string{"launch", entry.Path, config.URL}}
+	}
+
+	command := config.Name
+	var args []string
+	if entry != nil {
+		if entryCommand := entry.Command(); len(entryCommand) > 0 {
+			command, args = entryCommand[0], entryCommand[1:]
+		}
+	}
+
+	profileDir := ""
+	if profile != nil {
+		args = append(args, profile.Args...)
+		profileDir = profile.Dir
+	}
+	args = append(args, modeArgs...)
+
+	// Args up to --args are for macOS's open, not the browser
+	customArgs := config.Args
+	if i := slices.Index(customArgs, "--args"); i >= 0 {
+		customArgs = customArgs[i+1:]
+	}
+	customArgs, placed := expandArgPlaceholders(customArgs, config.URL, profileDir)
+	args = append(args, customArgs...)
+	if !placed && !modeHasURL {
+		args = append(args, config.URL)
+	}
+
+	return LaunchPlan{Browser: config.Name, Command: command, Args: args, Detach: true}
+}
@@ -0,0 +1,74 @@
+package browser
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SystemDefault is the browser that handled http and https links before
+// Finicky became the default browser.
+type SystemDefault struct {
+	BundleID   string    `json:"bundleId"`
+	RecordedAt time.Time `json:"recordedAt"`
+}
+
+var systemDefaultPath string
+
+// SetSystemDefaultPath overrides where the system default browser is
+// recorded. Pass an empty string to revert to the default. Intended for
+// testing.
+func SetSystemDefaultPath(path string) {
+	systemDefaultPath = path
+}
+
+func getSystemDefaultPath() (string, error) {
+	if systemDefaultPath != "" {
+		return systemDefaultPath, nil
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "Finicky", "system_default_browser.json"), nil
+}
+
+// RecordSystemDefault saves bundleID as the system default browser, unless
+// one was already recorded: the first browser Finicky replaced is kept.
+func RecordSystemDefault(bundleID string) error {
+	path, err := getSystemDefaultPath()
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(SystemDefault{BundleID: bundleID, RecordedAt: time.Now()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// GetSystemDefault returns the recorded system default browser's bundle ID,
+// or an empty string if none was recorded.
+func GetSystemDefault() string {
+	path, err := getSystemDefaultPath()
+	if err != nil {
+		return ""
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	var systemDefault SystemDefault
+	if err := json.Unmarshal(data, &systemDefault); err != nil {
+		return ""
+	}
+	return systemDefault.BundleID
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"finicky/batch"
+	"finicky/browser"
+	"finicky/config"
+	"finicky/configtest"
+	"finicky/resolver"
+	"finicky/rules"
+	"finicky/shorturl"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+)
+
+// runCommand runs a headless subcommand instead of the Cocoa app and returns
+// the process exit code. Results are written to stdout, logs go to stderr.
+func runCommand(args []string, customConfigPath string, namespace string) int {
+	switch args[0] {
+	case "resolve":
+		return runResolve(args[1:], customConfigPath, namespace)
+	case "batch":
+		return runBatch(args[1:], customConfigPath, namespace)
+	case "test":
+		return runTest(args[1:], customConfigPath, namespace)
+	case "diff":
+		return runDiff(args[1:], namespace)
+	case "cache":
+		return runCache(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n", args[0])
+		printCommandUsage(os.Stderr)
+		return 2
+	}
+}
+
+func printCommandUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: Finicky [flags] <command> [command flags]")
+	fmt.Fprintln(w, "")
+	fmt.Fprintln(w, "Commands:")
+	fmt.Fprintln(w, "  resolve <url>   Print where a URL would be opened, as JSON. Use -explain to include a trace")
+	fmt.Fprintln(w, "  batch [file]    Resolve one URL per line from a file or stdin, printing JSON lines")
+	fmt.Fprintln(w, "  test            Run the routing tests declared in the config and its .test.json file")
+	fmt.Fprintln(w, "  diff <old> <new> [file]")
+	fmt.Fprintln(w, "                  List the URLs from a file or stdin that two configs route differently")
+	fmt.Fprintln(w, "  cache list|clear")
+	fmt.Fprintln(w, "                  Show or clear the cached short URL expansions")
+}
+
+// loadCommandVM loads the configuration the same way the app does on startup,
+// but without touching the app's window, log file or config state. It returns
+// the VM with the path of the config it was loaded from, and a nil VM when
+// there is neither a JS config nor any JSON rules.
+func loadCommandVM(customConfigPath string, namespace string) (*config.VM, string, error) {
+	// JS configs are merged with the JSON rules on disk, same as in the app.
+	rf, rulesErr := rules.Load()
+	if rulesErr != nil {
+		slog.Warn("Failed to load rules file", "error", rulesErr)
+	} else {
+		resolver.SetCachedRules(rf)
+	}
+
+	if !skipJSConfig {
+		cfw, err := config.NewConfigFileWatcher(customConfigPath, namespace, make(chan struct{}, 1))
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to setup config file watcher: %v", err)
+		}
+		defer cfw.TearDown()
+
+		bundlePath, configPath, err := cfw.BundleConfig()
+		if err != nil {
+			return nil, configPath, config.WithConfigPath(fmt.Errorf("failed to read config: %w", err), configPath)
+		}
+		if bundlePath != "" {
+			vm, err := config.New(finickyConfigAPIJS, namespace, bundlePath)
+			if err != nil {
+				return nil, configPath, config.WithConfigPath(err, configPath)
+			}
+			return vm, configPath, nil
+		}
+	}
+
+	if rulesErr != nil || (rf.DefaultBrowser == "" && len(rf.Rules) == 0) {
+		return nil, "", nil
+	}
+	configPath, _ := rules.GetPath()
+	return config.NewFromRules(rf), configPath, nil
+}
+
+// loadVMFromPath builds a VM from a single config source: a rules file when
+// path ends in .json, otherwise a JS or TS config file.
+func loadVMFromPath(path string, namespace string) (*config.VM, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+
+	if filepath.Ext(path) == ".json" {
+		rf, err := rules.LoadFromPath(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load rules file: %v", err)
+		}
+		return config.NewFromRules(rf), nil
+	}
+
+	cfw, err := config.NewConfigFileWatcher(path, namespace, make(chan struct{}, 1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to setup config file watcher: %v", err)
+	}
+	defer cfw.TearDown()
+
+	bundlePath, _, err := cfw.BundleConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %v", err)
+	}
+	return config.New(finickyConfigAPIJS, namespace, bundlePath)
+}
+
+func runResolve(args []string, customConfigPath string, namespace string) int {
+	flags := flag.NewFlagSet("resolve", flag.ContinueOnError)
+	openerName := flags.String("opener-name", "", "Name of the app the URL was opened from")
+	openerBundleID := flags.String("opener-bundle-id", "", "Bundle ID of the app the URL was opened from")
+	openerPath := flags.String("opener-path", "", "Path of the app the URL was opened from")
+	openerWindowTitle := flags.String("opener-window-title", "", "Window title of the app the URL was opened from")
+	openInBackground := flags.Bool("background", false, "Resolve as if the URL was opened in the background")
+	explain := flags.Bool("explain", false, "Include a trace of the rewrites and handlers that were evaluated")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: Finicky [flags] resolve [resolve flags] <url>")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	var opener *resolver.OpenerInfo
+	if *openerName != "" || *openerBundleID != "" || *openerPath != "" || *openerWindowTitle != "" {
+		opener = &resolver.OpenerInfo{
+			Name:        *openerName,
+			BundleID:    *openerBundleID,
+			Path:        *openerPath,
+			WindowTitle: *openerWindowTitle,
+		}
+	}
+
+	vm, _, err := loadCommandVM(customConfigPath, namespace)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+
+	var cfg *browser.BrowserConfig
+	var trace *resolver.Trace
+	var resolveErr error
+	if *explain {
+		cfg, trace, resolveErr = resolver.Explain(vm, flags.Arg(0), opener, *openInBackground)
+	} else {
+		cfg, resolveErr = resolver.ResolveURL(vm, flags.Arg(0), opener, *openInBackground)
+	}
+	result := struct {
+		browser.BrowserResult
+		Fallback string          `json:"fallback,omitempty"`
+		Trace    *resolver.Trace `json:"trace,omitempty"`
+	}{
+		BrowserResult: browser.BrowserResult{Browser: *cfg},
+		Trace:         trace,
+	}
+	if resolveErr != nil {
+		result.Error = resolveErr.Error()
+		var fallbackErr *resolver.FallbackError
+		if errors.As(resolveErr, &fallbackErr) {
+			result.Fallback = fallbackErr.Fallback
+		}
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(result); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write result: %v\n", err)
+		return 1
+	}
+
+	if resolveErr != nil {
+		return 1
+	}
+	return 0
+}
+
+func runBatch(args []string, customConfigPath string, namespace string) int {
+	flags := flag.NewFlagSet("batch", flag.ContinueOnError)
+	timing := flags.Bool("timing", true, "Include per-URL evaluation time in the output")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: Finicky [flags] batch [batch flags] [file]")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintln(flags.Output(), "Reads plain URLs or JSON objects ({\"url\", \"opener\", \"openInBackground\"}),")
+		fmt.Fprintln(flags.Output(), "one per line, from file or stdin and prints one JSON result per line.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 1 {
+		flags.Usage()
+		return 2
+	}
+
+	input := io.Reader(os.Stdin)
+	if path := flags.Arg(0); path != "" && path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open input: %v\n", err)
+			return 1
+		}
+		defer file.Close()
+		input = file
+	}
+
+	vm, _, err := loadCommandVM(customConfigPath, namespace)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+
+	failures, err := batch.Run(vm, input, os.Stdout, batch.Options{Timing: *timing})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if failures > 0 {
+		slog.Warn("Some URLs failed to resolve", "count", failures)
+	}
+	return 0
+}
+
+func runTest(args []string, customConfigPath string, namespace string) int {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: Finicky [flags] test")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintln(flags.Output(), "Runs the tests in the config's tests key and in the sibling test file,")
+		fmt.Fprintln(flags.Output(), "e.g. ~/.finicky.test.json for ~/.finicky.js.")
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() > 0 {
+		flags.Usage()
+		return 2
+	}
+
+	vm, configPath, err := loadCommandVM(customConfigPath, namespace)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 1
+	}
+
+	cases, err := configtest.Discover(vm, configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load tests: %v\n", err)
+		return 1
+	}
+	if len(cases) == 0 {
+		fmt.Fprintln(os.Stderr, "No tests found")
+		return 1
+	}
+
+	if failed := configtest.WriteReport(os.Stdout, configtest.Run(vm, cases)); failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func runDiff(args []string, namespace string) int {
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	jsonOutput := flags.Bool("json", false, "Print the changes as JSON")
+	rulesPath := flags.String("rules", "", "Merge the rules in this rules.json file into both JS configs, as the app does")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: Finicky [flags] diff [diff flags] <old config> <new config> [file]")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintln(flags.Output(), "Configs are JS/TS config files or rules.json files. The URLs are read from")
+		fmt.Fprintln(flags.Output(), "file or stdin, in the same format as the batch command. Exits with 1 if any")
+		fmt.Fprintln(flags.Output(), "URL would open differently. Only the two configs are compared, unless -rules")
+		fmt.Fprintln(flags.Output(), "names a rules file to merge into both.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() < 2 || flags.NArg() > 3 {
+		flags.Usage()
+		return 2
+	}
+
+	input := io.Reader(os.Stdin)
+	if path := flags.Arg(2); path != "" && path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open input: %v\n", err)
+			return 2
+		}
+		defer file.Close()
+		input = file
+	}
+	requests, err := batch.ReadRequests(input)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if *rulesPath != "" {
+		rf, err := rules.LoadFromPath(*rulesPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load rules file: %v\n", err)
+			return 2
+		}
+		resolver.SetCachedRules(rf)
+	}
+
+	oldVM, err := loadVMFromPath(flags.Arg(0), namespace)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load %s: %v\n", flags.Arg(0), err)
+		return 2
+	}
+	newVM, err := loadVMFromPath(flags.Arg(1), namespace)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load %s: %v\n", flags.Arg(1), err)
+		return 2
+	}
+
+	changes := batch.Compare(oldVM, newVM, requests)
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if changes == nil {
+			changes = []batch.Change{}
+		}
+		err := encoder.Encode(struct {
+			Total   int            `json:"total"`
+			Changes []batch.Change `json:"changes"`
+		}{len(requests), changes})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write result: %v\n", err)
+			return 2
+		}
+	} else {
+		batch.WriteChanges(os.Stdout, changes, len(requests))
+	}
+
+	if len(changes) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func runCache(args []string) int {
+	flags := flag.NewFlagSet("cache", flag.ContinueOnError)
+	jsonOutput := flags.Bool("json", false, "Print the entries as JSON")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: Finicky [flags] cache [cache flags] list|clear")
+		fmt.Fprintln(flags.Output(), "")
+		fmt.Fprintln(flags.Output(), "list prints the cached short URL expansions, most recent first. clear removes them.")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	cache := shorturl.DefaultCache()
+	switch flags.Arg(0) {
+	case "list":
+		entries := cache.List()
+		if *jsonOutput {
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(entries); err != nil {
+				fmt.Fprintf(os.Stderr, "Failed to write result: %v\n", err)
+				return 2
+			}
+			return 0
+		}
+		for _, entry := range entries {
+			result := entry.URL
+			if entry.Error != "" {
+				result = "failed: " + entry.Error
+			}
+			fmt.Printf("%s  %s -> %s\n", entry.ResolvedAt.Local().Format("2006-01-02 15:04"), entry.ShortURL, result)
+		}
+		fmt.Fprintf(os.Stderr, "%d cached short URLs in %s\n", len(entries), cache.Path())
+		return 0
+	case "clear":
+		n, err := cache.Clear()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to clear cache: %v\n", err)
+			return 2
+		}
+		fmt.Fprintf(os.Stderr, "Removed %d cached short URLs\n", n)
+		return 0
+	default:
+		flags.Usage()
+		return 2
+	}
+}
@@ -133,7 +133,7 @@ func (cfw *ConfigFileWatcher) BundleConfig() (string, string, error) {
 	// Apply babel transformation
 	transformedPath, err := cfw.babelTransform(configPath)
 	if err != nil {
-		return "", configPath, err
+		return "", configPath, newBabelError(err, configPath)
 	}
 
 	slog.Debug("Bundling config")
@@ -151,6 +151,8 @@ func (cfw *ConfigFileWatcher) BundleConfig() (string, string, error) {
 		Target:      api.ES2015,
 		Format:      api.FormatIIFE,
 		GlobalName:  cfw.namespace,
+		// Lets runtime errors be reported at their position in the config
+		Sourcemap: api.SourceMapInline,
 		Loader: map[string]api.Loader{
 			".ts.symlink": api.LoaderTS,
 			".js.symlink": api.LoaderJS,
@@ -162,7 +164,16 @@ func (cfw *ConfigFileWatcher) BundleConfig() (string, string, error) {
 		for _, err := range result.Errors {
 			errorTexts = append(errorTexts, err.Text)
 		}
-		return "", configPath, fmt.Errorf("build errors: %s", strings.Join(errorTexts, ", "))
+		cfgErr := &ConfigError{
+			File:    configPath,
+			Message: fmt.Sprintf("build errors: %s", strings.Join(errorTexts, ", ")),
+		}
+		if loc := result.Errors[0].Location; loc != nil {
+			cfgErr.File = sourceFile(loc.File, configPath)
+			cfgErr.Line = loc.Line
+			cfgErr.Column = loc.Column + 1
+		}
+		return "", configPath, cfgErr
 	}
 
 	// Update cache
@@ -196,6 +207,8 @@ func (cfw *ConfigFileWatcher) babelTransform(configPath string) (string, error)
 		"plugins": []string{
 			"transform-named-capturing-groups-regex",
 		},
+		// Keep line numbers so errors point at the right line in the config
+		"retainLines": true,
 	})
 
 	if err != nil {
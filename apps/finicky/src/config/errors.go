@@ -0,0 +1,115 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dop251/goja"
+)
+
+// ConfigError describes why a configuration failed to load. File, Line and
+// Column point at the problem when it could be located.
+type ConfigError struct {
+	File    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *ConfigError) Error() string {
+	switch {
+	case e.File != "" && e.Line > 0:
+		return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Message)
+	case e.File != "":
+		return fmt.Sprintf("%s: %s", e.File, e.Message)
+	default:
+		return e.Message
+	}
+}
+
+// TimeoutError is returned when a script runs longer than the evaluation
+// budget and is interrupted.
+type TimeoutError struct {
+	Timeout time.Duration
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("evaluation timed out after %dms", e.Timeout.Milliseconds())
+}
+
+// WithConfigPath returns err as a *ConfigError attributed to configPath.
+// Errors without a file, or located in Finicky's babel output for the config,
+// are attributed to configPath itself.
+func WithConfigPath(err error, configPath string) error {
+	if err == nil {
+		return nil
+	}
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) {
+		return &ConfigError{File: configPath, Message: err.Error()}
+	}
+	attributed := *cfgErr
+	attributed.File = sourceFile(cfgErr.File, configPath)
+	return &attributed
+}
+
+// sourceFile maps a file reported by esbuild or a source map back to the
+// user's config when it refers to a generated file.
+func sourceFile(file string, configPath string) string {
+	if file == "" || strings.HasPrefix(filepath.Base(file), "finicky_babel_") {
+		return configPath
+	}
+	return file
+}
+
+// babelPosition matches the (line:column) suffix of babel syntax errors.
+// Babel columns are zero-based.
+var babelPosition = regexp.MustCompile(`\((\d+):(\d+)\)$`)
+
+// newBabelError keeps the first line of a babel error, dropping the code
+// frame and stack that follow it.
+func newBabelError(err error, configPath string) *ConfigError {
+	message, _, _ := strings.Cut(err.Error(), "\n")
+	cfgErr := &ConfigError{File: configPath, Message: message}
+	if m := babelPosition.FindStringSubmatch(message); m != nil {
+		cfgErr.Line, _ = strconv.Atoi(m[1])
+		column, _ := strconv.Atoi(m[2])
+		cfgErr.Column = column + 1
+	}
+	return cfgErr
+}
+
+// newScriptError converts an error from running a script into a ConfigError,
+// located at the innermost stack frame that belongs to a named script. With
+// the inline source map in the bundle, that is a position in the config's
+// source rather than in the bundle.
+func newScriptError(err error, message string) *ConfigError {
+	cfgErr := &ConfigError{Message: fmt.Sprintf("%s: %v", message, err)}
+
+	var stack []goja.StackFrame
+	var interrupted *goja.InterruptedError
+	var ex *goja.Exception
+	switch {
+	case errors.As(err, &interrupted):
+		cfgErr.Message = fmt.Sprintf("%s: %v", message, interrupted.Value())
+		stack = interrupted.Stack()
+	case errors.As(err, &ex):
+		cfgErr.Message = fmt.Sprintf("%s: %s", message, ex.Value().String())
+		stack = ex.Stack()
+	}
+	for _, frame := range stack {
+		pos := frame.Position()
+		if pos.Filename != "" {
+			cfgErr.File = pos.Filename
+			cfgErr.Line = pos.Line
+			cfgErr.Column = pos.Column
+			break
+		}
+	}
+	return cfgErr
+}
@@ -0,0 +1,70 @@
+package config_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "finicky/config"
+	"finicky/internal/testvm"
+)
+
+func TestNew_ErrorPosition(t *testing.T) {
+	bundlePath := filepath.Join(t.TempDir(), "finicky.js")
+	script := "var finickyConfig = { defaultBrowser: \"Safari\" };\n\n  notDefined();\n"
+	if err := os.WriteFile(bundlePath, []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := New(testvm.APIContent(t), "finickyConfig", bundlePath)
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) {
+		t.Fatalf("expected ConfigError, got %v", err)
+	}
+	if cfgErr.File != bundlePath || cfgErr.Line != 3 || cfgErr.Column == 0 {
+		t.Errorf("position: got %s:%d:%d", cfgErr.File, cfgErr.Line, cfgErr.Column)
+	}
+	if !strings.Contains(cfgErr.Message, "notDefined is not defined") {
+		t.Errorf("message: got %q", cfgErr.Message)
+	}
+}
+
+func TestNewFromScript_InvalidConfig(t *testing.T) {
+	_, err := NewFromScript(testvm.APIContent(t), "finickyConfig", `var finickyConfig = { defaultBrowser: 42 }`)
+	var cfgErr *ConfigError
+	if !errors.As(err, &cfgErr) || cfgErr.Message != "configuration is invalid" {
+		t.Errorf("expected invalid configuration error, got %v", err)
+	}
+}
+
+func TestWithConfigPath(t *testing.T) {
+	configPath := "/Users/me/.finicky.js"
+
+	cases := []struct {
+		err  error
+		want string
+	}{
+		{errors.New("boom"), "/Users/me/.finicky.js: boom"},
+		{&ConfigError{Message: "configuration is invalid"}, "/Users/me/.finicky.js: configuration is invalid"},
+		{&ConfigError{File: "/cache/transform/finicky_babel_0123456789ab.js", Line: 4, Column: 2, Message: "oops"}, "/Users/me/.finicky.js:4:2: oops"},
+		{&ConfigError{File: "/Users/me/lib/helpers.js", Line: 1, Column: 7, Message: "oops"}, "/Users/me/lib/helpers.js:1:7: oops"},
+	}
+	for _, c := range cases {
+		if got := WithConfigPath(c.err, configPath).Error(); got != c.want {
+			t.Errorf("WithConfigPath(%v) = %q, want %q", c.err, got, c.want)
+		}
+	}
+
+	if WithConfigPath(nil, configPath) != nil {
+		t.Error("expected nil for nil error")
+	}
+}
+
+func TestNewFromScript_Timeout(t *testing.T) {
+	_, err := NewFromScript(testvm.APIContent(t), "finickyConfig", "while (true) {}")
+	if err == nil || !strings.Contains(err.Error(), "evaluation timed out after 2000ms") {
+		t.Errorf("expected timeout error, got %v", err)
+	}
+}
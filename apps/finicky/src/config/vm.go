@@ -1,26 +1,46 @@
 package config
 
 import (
+	"encoding/json"
+	"finicky/rules"
+	"finicky/shorturl"
 	"finicky/util"
 	"fmt"
 	"log/slog"
 	"os"
+	"time"
 
 	"github.com/dop251/goja"
 )
 
+// DefaultEvaluationTimeout is the evaluation budget used when the config
+// doesn't set evaluationTimeoutMs. It also bounds the top-level evaluation of
+// the config, which runs before its options are known.
+const DefaultEvaluationTimeout = 2 * time.Second
+
 type VM struct {
-	runtime    *goja.Runtime
-	namespace  string
-	isJSConfig bool
+	runtime           *goja.Runtime
+	namespace         string
+	isJSConfig        bool
+	evaluationTimeout time.Duration
+	urlShorteners     []string
+	shortURLCacheTTL  time.Duration
+	rules             *rules.RulesFile
 }
 
 // ConfigOptions holds the values of all runtime config options.
 type ConfigOptions struct {
-	KeepRunning     bool
-	HideIcon        bool
-	LogRequests     bool
-	CheckForUpdates bool
+	KeepRunning       bool
+	HideIcon          bool
+	LogRequests       bool
+	CheckForUpdates   bool
+	EvaluationTimeout time.Duration
+	// URLShorteners lists the domains whose URLs are expanded, or is nil to
+	// use the built-in list.
+	URLShorteners []string
+	// ShortURLCacheTTL is how long a short URL expansion is reused. Zero
+	// disables the cache.
+	ShortURLCacheTTL time.Duration
 }
 
 // ConfigState represents the current state of the configuration
@@ -42,34 +62,44 @@ func New(apiContent []byte, namespace string, bundlePath string) (*VM, error) {
 			return nil, fmt.Errorf("failed to read file: %v", err)
 		}
 	}
-	vm, err := newFromContent(apiContent, namespace, content)
+	vm, err := newFromContent(apiContent, namespace, bundlePath, content)
 	if vm != nil {
 		vm.isJSConfig = true
 	}
 	return vm, err
 }
 
+// NewFromRules creates a VM for a rules file. It has no JavaScript runtime:
+// URLs are matched against the rules in Go, see Rules.
+func NewFromRules(rf rules.RulesFile) *VM {
+	return &VM{rules: &rf}
+}
+
 // NewFromScript creates a VM from an inline JavaScript config string.
 // apiContent is the pre-read bytes of finickyConfigAPI.js.
 func NewFromScript(apiContent []byte, namespace string, script string) (*VM, error) {
-	return newFromContent(apiContent, namespace, []byte(script))
+	return newFromContent(apiContent, namespace, "", []byte(script))
 }
 
-func newFromContent(apiContent []byte, namespace string, content []byte) (*VM, error) {
+// newFromContent evaluates content as the config script. name identifies the
+// script in error positions and lets goja apply its inline source map.
+func newFromContent(apiContent []byte, namespace string, name string, content []byte) (*VM, error) {
 	vm := &VM{
-		runtime:   goja.New(),
-		namespace: namespace,
+		runtime:           goja.New(),
+		namespace:         namespace,
+		evaluationTimeout: DefaultEvaluationTimeout,
 	}
-	if err := vm.setup(apiContent, content); err != nil {
+	if err := vm.setup(apiContent, name, content); err != nil {
 		return nil, err
 	}
 	return vm, nil
 }
 
-func (vm *VM) setup(apiContent []byte, content []byte) error {
+func (vm *VM) setup(apiContent []byte, name string, content []byte) error {
 
 	vm.runtime.Set("self", vm.runtime.GlobalObject())
 	vm.runtime.Set("console", GetConsoleMap())
+	vm.runtime.Set("_applyRulesRewrite", rules.ApplyRewriteJSON)
 
 	slog.Debug("Evaluating API script...")
 	if _, err := vm.runtime.RunString(string(apiContent)); err != nil {
@@ -92,34 +122,99 @@ func (vm *VM) setup(apiContent []byte, content []byte) error {
 	vm.runtime.Set("finicky", finicky)
 
 	if len(content) > 0 {
-		if _, err := vm.runtime.RunString(string(content)); err != nil {
-			return fmt.Errorf("error while running config script: %v", err)
+		_, err := vm.run(func() (goja.Value, error) {
+			return vm.runtime.RunScript(name, string(content))
+		})
+		if err != nil {
+			return newScriptError(err, "error while running config script")
 		}
 	} else {
 		vm.runtime.Set(vm.namespace, map[string]interface{}{})
 	}
 
 	vm.runtime.Set("namespace", vm.namespace)
-	finalConfig, err := vm.runtime.RunString("finickyConfigAPI.getConfiguration(namespace)")
+	finalConfig, err := vm.Run("finickyConfigAPI.getConfiguration(namespace)")
 	if err != nil {
-		return fmt.Errorf("failed to get merged config: %v", err)
+		return newScriptError(err, "failed to get merged config")
 	}
 
 	vm.runtime.Set("finalConfig", finalConfig)
 
-	validConfig, err := vm.runtime.RunString("finickyConfigAPI.validateConfig(finalConfig)")
+	validConfig, err := vm.Run("finickyConfigAPI.validateConfig(finalConfig)")
 	if err != nil {
 		return fmt.Errorf("failed to validate config: %v", err)
 	}
 	if !validConfig.ToBoolean() {
-		return fmt.Errorf("configuration is invalid")
+		return &ConfigError{Message: "configuration is invalid"}
 	}
 
+	opts := vm.GetAllConfigOptions()
+	vm.evaluationTimeout = opts.EvaluationTimeout
+	vm.urlShorteners = opts.URLShorteners
+	vm.shortURLCacheTTL = opts.ShortURLCacheTTL
+
 	return nil
 }
 
+// Run evaluates script, interrupting it with a *TimeoutError once it runs
+// longer than the config's evaluation budget. Only JavaScript can be
+// interrupted; a call into a native function, such as a single regular
+// expression match, finishes before the interrupt takes effect.
+func (vm *VM) Run(script string) (goja.Value, error) {
+	return vm.run(func() (goja.Value, error) {
+		return vm.runtime.RunString(script)
+	})
+}
+
+func (vm *VM) run(fn func() (goja.Value, error)) (goja.Value, error) {
+	fired := make(chan struct{})
+	timer := time.AfterFunc(vm.evaluationTimeout, func() {
+		defer close(fired)
+		vm.runtime.Interrupt(&TimeoutError{Timeout: vm.evaluationTimeout})
+	})
+	value, err := fn()
+	if !timer.Stop() {
+		// The timer fired, possibly just after fn returned. Wait for the
+		// interrupt to be set so it's cleared here instead of hitting the
+		// next evaluation.
+		<-fired
+	}
+	vm.runtime.ClearInterrupt()
+	return value, err
+}
+
+// EvaluationTimeout returns the budget for a single evaluation.
+func (vm *VM) EvaluationTimeout() time.Duration {
+	return vm.evaluationTimeout
+}
+
+// ShortURLCacheTTL returns how long a short URL expansion is reused.
+func (vm *VM) ShortURLCacheTTL() time.Duration {
+	if vm == nil || vm.runtime == nil {
+		return shorturl.DefaultCacheTTL
+	}
+	return vm.shortURLCacheTTL
+}
+
+// URLShorteners returns the domains whose URLs should be expanded, as set by
+// the config's urlShorteners option, or the built-in list when it isn't set.
+func (vm *VM) URLShorteners() []string {
+	if vm == nil || vm.urlShorteners == nil {
+		return shorturl.DefaultDomains()
+	}
+	return vm.urlShorteners
+}
+
 func (vm *VM) GetConfigState() *ConfigState {
-	state, err := vm.runtime.RunString("finickyConfigAPI.getConfigState(finalConfig)")
+	if vm.rules != nil {
+		return &ConfigState{
+			Handlers:       int16(len(rules.HandlerRuleIndexes(vm.rules.Rules))),
+			Rewrites:       int16(len(vm.rules.Rewrites)),
+			DefaultBrowser: vm.rules.DefaultTarget().Browser,
+		}
+	}
+
+	state, err := vm.Run("finickyConfigAPI.getConfigState(finalConfig)")
 	if err != nil {
 		slog.Error("Failed to get config state", "error", err)
 		return nil
@@ -140,6 +235,27 @@ func (vm *VM) GetConfigState() *ConfigState {
 	}
 }
 
+// GetTestsJSON returns the routing tests declared under the config's tests
+// key, serialized as a JSON array.
+func (vm *VM) GetTestsJSON() ([]byte, error) {
+	if vm.rules != nil {
+		return []byte("[]"), nil
+	}
+	tests, err := vm.Run("JSON.stringify(finickyConfigAPI.getTests(finalConfig))")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tests: %w", err)
+	}
+	return []byte(tests.String()), nil
+}
+
+// Rules returns the rules file of a VM created with NewFromRules, or nil.
+func (vm *VM) Rules() *rules.RulesFile {
+	if vm == nil {
+		return nil
+	}
+	return vm.rules
+}
+
 // IsJSConfig reports whether this VM was built from a JS config file.
 func (vm *VM) IsJSConfig() bool {
 	return vm.isJSConfig
@@ -154,35 +270,98 @@ func (vm *VM) SetIsJSConfig(v bool) {
 // Safe to call on a nil VM — returns defaults in that case.
 func (vm *VM) GetAllConfigOptions() ConfigOptions {
 	defaults := ConfigOptions{
-		KeepRunning:     true,
-		HideIcon:        false,
-		LogRequests:     false,
-		CheckForUpdates: true,
+		KeepRunning:       true,
+		HideIcon:          false,
+		LogRequests:       false,
+		CheckForUpdates:   true,
+		EvaluationTimeout: DefaultEvaluationTimeout,
+		ShortURLCacheTTL:  shorturl.DefaultCacheTTL,
+	}
+	if vm != nil && vm.rules != nil {
+		return rulesConfigOptions(vm.rules.Options, defaults)
 	}
 	if vm == nil || vm.runtime == nil {
 		return defaults
 	}
 	script := `({
-		keepRunning:     finickyConfigAPI.getOption('keepRunning',     finalConfig, true),
-		hideIcon:        finickyConfigAPI.getOption('hideIcon',        finalConfig, false),
-		logRequests:     finickyConfigAPI.getOption('logRequests',     finalConfig, false),
-		checkForUpdates: finickyConfigAPI.getOption('checkForUpdates', finalConfig, true)
+		keepRunning:           finickyConfigAPI.getOption('keepRunning',           finalConfig, true),
+		hideIcon:              finickyConfigAPI.getOption('hideIcon',              finalConfig, false),
+		logRequests:           finickyConfigAPI.getOption('logRequests',           finalConfig, false),
+		checkForUpdates:       finickyConfigAPI.getOption('checkForUpdates',       finalConfig, true),
+		evaluationTimeoutMs:   finickyConfigAPI.getOption('evaluationTimeoutMs',   finalConfig, %d),
+		urlShorteners:         finickyConfigAPI.getOption('urlShorteners',         finalConfig, null),
+		shortUrlCacheTtlHours: finickyConfigAPI.getOption('shortUrlCacheTtlHours', finalConfig, %d)
 	})`
-	val, err := vm.runtime.RunString(script)
+	val, err := vm.Run(fmt.Sprintf(script, DefaultEvaluationTimeout.Milliseconds(), int64(shorturl.DefaultCacheTTL/time.Hour)))
 	if err != nil {
 		slog.Error("Failed to get config options", "error", err)
 		return defaults
 	}
 	obj := val.ToObject(vm.runtime)
 	return ConfigOptions{
-		KeepRunning:     obj.Get("keepRunning").ToBoolean(),
-		HideIcon:        obj.Get("hideIcon").ToBoolean(),
-		LogRequests:     obj.Get("logRequests").ToBoolean(),
-		CheckForUpdates: obj.Get("checkForUpdates").ToBoolean(),
+		KeepRunning:       obj.Get("keepRunning").ToBoolean(),
+		HideIcon:          obj.Get("hideIcon").ToBoolean(),
+		LogRequests:       obj.Get("logRequests").ToBoolean(),
+		CheckForUpdates:   obj.Get("checkForUpdates").ToBoolean(),
+		EvaluationTimeout: time.Duration(obj.Get("evaluationTimeoutMs").ToInteger()) * time.Millisecond,
+		URLShorteners:     vm.urlShortenersOption(obj.Get("urlShorteners")),
+		ShortURLCacheTTL:  time.Duration(obj.Get("shortUrlCacheTtlHours").ToFloat() * float64(time.Hour)),
+	}
+}
+
+// urlShortenersOption reads the urlShorteners option: a list replaces the
+// built-in domains, and a function is called with them and returns the list
+// to use. It returns nil when the option isn't set or is invalid.
+func (vm *VM) urlShortenersOption(option goja.Value) []string {
+	if option == nil || goja.IsUndefined(option) || goja.IsNull(option) {
+		return nil
+	}
+	if fn, ok := goja.AssertFunction(option); ok {
+		var err error
+		option, err = vm.run(func() (goja.Value, error) {
+			return fn(goja.Undefined(), vm.runtime.ToValue(shorturl.DefaultDomains()))
+		})
+		if err != nil {
+			slog.Error("Failed to evaluate urlShorteners option", "error", err)
+			return nil
+		}
+	}
+	// Round-trip through JSON so only an array of strings is accepted.
+	data, err := json.Marshal(option.Export())
+	if err != nil {
+		slog.Error("Invalid urlShorteners option", "error", err)
+		return nil
+	}
+	domains := []string{}
+	if err := json.Unmarshal(data, &domains); err != nil {
+		slog.Error("Invalid urlShorteners option, expected a list of domains", "error", err)
+		return nil
+	}
+	return domains
+}
+
+func rulesConfigOptions(options *rules.Options, defaults ConfigOptions) ConfigOptions {
+	if options == nil {
+		return defaults
+	}
+	opts := defaults
+	if options.KeepRunning != nil {
+		opts.KeepRunning = *options.KeepRunning
+	}
+	if options.HideIcon != nil {
+		opts.HideIcon = *options.HideIcon
+	}
+	if options.LogRequests != nil {
+		opts.LogRequests = *options.LogRequests
+	}
+	if options.CheckForUpdates != nil {
+		opts.CheckForUpdates = *options.CheckForUpdates
 	}
+	return opts
 }
 
-// Runtime returns the underlying goja.Runtime
+// Runtime returns the underlying goja.Runtime. It is nil for a VM created
+// with NewFromRules.
 func (vm *VM) Runtime() *goja.Runtime {
 	return vm.runtime
 }
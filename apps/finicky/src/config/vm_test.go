@@ -0,0 +1,110 @@
+package config_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	. "finicky/config"
+	"finicky/internal/testvm"
+	"finicky/rules"
+	"finicky/shorturl"
+)
+
+func TestVM_URLShorteners(t *testing.T) {
+	defaults := shorturl.DefaultDomains()
+	cases := []struct {
+		name    string
+		options string
+		want    []string
+	}{
+		{"unset", `{}`, defaults},
+		{"replace", `{urlShorteners: ["example.link", "go.example.com"]}`, []string{"example.link", "go.example.com"}},
+		{"disable", `{urlShorteners: []}`, []string{}},
+		{"add", `{urlShorteners: (domains) => [...domains, "example.link"]}`, append(defaults, "example.link")},
+		{"function throws", `{urlShorteners: () => { throw new Error("oops") }}`, defaults},
+	}
+	for _, c := range cases {
+		script := `var finickyConfig = {defaultBrowser: "Safari", options: ` + c.options + `};`
+		vm, err := NewFromScript(testvm.APIContent(t), "finickyConfig", script)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		if got := vm.URLShorteners(); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	if got := NewFromRules(rules.RulesFile{}).URLShorteners(); !reflect.DeepEqual(got, defaults) {
+		t.Errorf("rules VM: got %v, want the built-in domains", got)
+	}
+}
+
+func TestVM_ShortURLCacheTTL(t *testing.T) {
+	cases := []struct {
+		options string
+		want    time.Duration
+	}{
+		{`{}`, shorturl.DefaultCacheTTL},
+		{`{shortUrlCacheTtlHours: 1.5}`, 90 * time.Minute},
+		{`{shortUrlCacheTtlHours: 0}`, 0},
+	}
+	for _, c := range cases {
+		script := `var finickyConfig = {defaultBrowser: "Safari", options: ` + c.options + `};`
+		vm, err := NewFromScript(testvm.APIContent(t), "finickyConfig", script)
+		if err != nil {
+			t.Fatalf("%s: %v", c.options, err)
+		}
+		if got := vm.ShortURLCacheTTL(); got != c.want {
+			t.Errorf("%s: got %v, want %v", c.options, got, c.want)
+		}
+	}
+}
+
+func TestVM_GetConfigState(t *testing.T) {
+	vm := NewFromRules(rules.RulesFile{
+		DefaultBrowser: "Firefox",
+		Rules: []rules.Rule{
+			{Match: []string{"*github.com/*"}, Browser: "Google Chrome"},
+			{Match: []string{"*example.com/*"}, Browser: "Safari"},
+		},
+		Rewrites: []rules.Rewrite{{Match: []string{"http://*"}, ForceHTTPS: true}},
+	})
+	want := ConfigState{Handlers: 2, Rewrites: 1, DefaultBrowser: "Firefox"}
+	if got := vm.GetConfigState(); got == nil || *got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestVM_GettersRunWithinBudget(t *testing.T) {
+	script := `var stuck = false;
+	var finickyConfig = {
+		defaultBrowser: "Safari",
+		options: {
+			evaluationTimeoutMs: 50,
+			get logRequests() { while (stuck) {} return true },
+		},
+		get tests() { while (stuck) {} return [] },
+	};`
+	vm, err := NewFromScript(testvm.APIContent(t), "finickyConfig", script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vm.Run("stuck = true"); err != nil {
+		t.Fatal(err)
+	}
+
+	if opts := vm.GetAllConfigOptions(); opts.LogRequests {
+		t.Error("expected the default options when a getter runs over the budget")
+	}
+	var timeoutErr *TimeoutError
+	if _, err := vm.GetTestsJSON(); !errors.As(err, &timeoutErr) {
+		t.Errorf("expected a TimeoutError from the tests getter, got %v", err)
+	}
+
+	// The interrupts don't carry over to the next evaluation
+	if _, err := vm.Run("stuck = false"); err != nil {
+		t.Errorf("expected the next evaluation to run, got %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package config
+
+import "sync/atomic"
+
+// Worker owns the active VM and runs every evaluation against it on a single
+// goroutine. goja.Runtime is not safe for concurrent use, and evaluating a URL
+// mutates runtime globals (url, opener, originalUrl), so URL opens, test URLs
+// from the window and option reads must never interleave.
+type Worker struct {
+	vm       atomic.Pointer[VM]
+	requests chan func()
+}
+
+// NewWorker starts a worker with no active VM.
+func NewWorker() *Worker {
+	w := &Worker{requests: make(chan func())}
+	go w.run()
+	return w
+}
+
+func (w *Worker) run() {
+	for request := range w.requests {
+		request()
+	}
+}
+
+// Do runs fn on the worker goroutine with the active VM, which may be nil,
+// and waits for it to return. fn must not call Do itself.
+func (w *Worker) Do(fn func(vm *VM)) {
+	done := make(chan struct{})
+	w.requests <- func() {
+		defer close(done)
+		fn(w.vm.Load())
+	}
+	<-done
+}
+
+// Swap makes vm the active VM and returns the previous one. The new VM must
+// not be shared with any other goroutine. An evaluation that is already
+// running finishes on the VM it started with.
+func (w *Worker) Swap(vm *VM) *VM {
+	return w.vm.Swap(vm)
+}
+
+// IsJSConfig reports whether there is an active VM built from a JS config.
+// It only reads immutable VM state, so it doesn't go through the worker.
+func (w *Worker) IsJSConfig() bool {
+	vm := w.vm.Load()
+	return vm != nil && vm.IsJSConfig()
+}
+
+// GetAllConfigOptions reads the options of the active VM. Returns defaults
+// when there is no active VM.
+func (w *Worker) GetAllConfigOptions() ConfigOptions {
+	var opts ConfigOptions
+	w.Do(func(vm *VM) {
+		opts = vm.GetAllConfigOptions()
+	})
+	return opts
+}
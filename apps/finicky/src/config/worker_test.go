@@ -0,0 +1,84 @@
+package config_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "finicky/config"
+)
+
+func TestWorker_SerializesEvaluations(t *testing.T) {
+	w := NewWorker()
+	w.Swap(new(VM))
+
+	var inFlight atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.Do(func(vm *VM) {
+				defer inFlight.Add(-1)
+				if n := inFlight.Add(1); n > 1 {
+					t.Errorf("expected evaluations to run one at a time, saw %d at once", n)
+				}
+				time.Sleep(time.Millisecond)
+			})
+		}()
+	}
+	wg.Wait()
+}
+
+func TestWorker_Swap(t *testing.T) {
+	w := NewWorker()
+
+	w.Do(func(vm *VM) {
+		if vm != nil {
+			t.Errorf("expected no active VM, got %p", vm)
+		}
+	})
+
+	first := new(VM)
+	if prev := w.Swap(first); prev != nil {
+		t.Errorf("expected no previous VM, got %p", prev)
+	}
+
+	// A swap from another goroutine while an evaluation is running doesn't
+	// change the VM that evaluation uses.
+	second := new(VM)
+	started, swapped, done := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(done)
+		w.Do(func(vm *VM) {
+			close(started)
+			<-swapped
+			if vm != first {
+				t.Errorf("expected the running evaluation to keep the first VM")
+			}
+		})
+	}()
+	<-started
+	if prev := w.Swap(second); prev != first {
+		t.Errorf("expected Swap to return the first VM, got %p", prev)
+	}
+	close(swapped)
+	<-done
+
+	w.Do(func(vm *VM) {
+		if vm != second {
+			t.Errorf("expected the swapped-in VM to be active")
+		}
+	})
+}
+
+func TestWorker_NilVMDefaults(t *testing.T) {
+	w := NewWorker()
+	if w.IsJSConfig() {
+		t.Error("expected IsJSConfig=false without a VM")
+	}
+	if opts := w.GetAllConfigOptions(); !opts.KeepRunning || !opts.CheckForUpdates {
+		t.Errorf("expected default options, got %+v", opts)
+	}
+}
@@ -0,0 +1,184 @@
+package configtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"finicky/browser"
+	"finicky/config"
+	"finicky/resolver"
+)
+
+// Case is a single routing assertion: opening URL (optionally from Opener)
+// should result in the browser described by Expect.
+type Case struct {
+	Name             string               `json:"name,omitempty"`
+	URL              string               `json:"url"`
+	Opener           *resolver.OpenerInfo `json:"opener,omitempty"`
+	OpenInBackground bool                 `json:"openInBackground,omitempty"`
+	Expect           Expectation          `json:"expect"`
+
+	// Source is where the case was declared, for reporting.
+	Source string `json:"-"`
+}
+
+// Expectation lists the expected decision. Nil fields are not checked, so an
+// explicit empty profile can be used to assert that no profile is selected.
+type Expectation struct {
+	Browser *string `json:"browser,omitempty"`
+	Profile *string `json:"profile,omitempty"`
+	URL     *string `json:"url,omitempty"`
+}
+
+// Diff is a single mismatch between the expected and actual decision.
+type Diff struct {
+	Field    string
+	Expected string
+	Actual   string
+}
+
+// Outcome is the result of running a single Case.
+type Outcome struct {
+	Case   Case
+	Actual *browser.BrowserConfig
+	Error  string
+	Diffs  []Diff
+}
+
+// Passed reports whether the case resolved without error and matched every
+// expected field.
+func (o Outcome) Passed() bool {
+	return o.Error == "" && len(o.Diffs) == 0
+}
+
+// SiblingPath returns the path of the test file that belongs to a config
+// file, e.g. ~/.config/finicky/finicky.test.json for finicky.ts.
+func SiblingPath(configPath string) string {
+	base := strings.TrimSuffix(configPath, filepath.Ext(configPath))
+	return base + ".test.json"
+}
+
+// LoadFile reads test cases from a JSON file containing an array of cases.
+// Returns no cases and no error if the file doesn't exist.
+func LoadFile(path string) ([]Case, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cases, err := parseCases(data, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return cases, nil
+}
+
+// Discover collects the test cases declared in the config's tests key and in
+// the sibling test file of configPath. Config tests come first.
+func Discover(vm *config.VM, configPath string) ([]Case, error) {
+	var cases []Case
+
+	if vm != nil {
+		data, err := vm.GetTestsJSON()
+		if err != nil {
+			return nil, err
+		}
+		configCases, err := parseCases(data, "config")
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse tests in config: %v", err)
+		}
+		cases = append(cases, configCases...)
+	}
+
+	if configPath != "" {
+		fileCases, err := LoadFile(SiblingPath(configPath))
+		if err != nil {
+			return nil, err
+		}
+		cases = append(cases, fileCases...)
+	}
+
+	return cases, nil
+}
+
+func parseCases(data []byte, source string) ([]Case, error) {
+	var cases []Case
+	if err := json.Unmarshal(data, &cases); err != nil {
+		return nil, err
+	}
+	for i := range cases {
+		if cases[i].URL == "" {
+			return nil, fmt.Errorf("test %d is missing url", i+1)
+		}
+		cases[i].Source = source
+	}
+	return cases, nil
+}
+
+// Run resolves every case against vm and compares the result with its
+// expectation.
+func Run(vm *config.VM, cases []Case) []Outcome {
+	outcomes := make([]Outcome, 0, len(cases))
+	for _, c := range cases {
+		outcomes = append(outcomes, runCase(vm, c))
+	}
+	return outcomes
+}
+
+func runCase(vm *config.VM, c Case) Outcome {
+	outcome := Outcome{Case: c}
+
+	cfg, err := resolver.ResolveURL(vm, c.URL, c.Opener, c.OpenInBackground)
+	outcome.Actual = cfg
+	if err != nil {
+		outcome.Error = err.Error()
+		return outcome
+	}
+
+	check := func(field string, expected *string, actual string) {
+		if expected != nil && *expected != actual {
+			outcome.Diffs = append(outcome.Diffs, Diff{Field: field, Expected: *expected, Actual: actual})
+		}
+	}
+	check("browser", c.Expect.Browser, cfg.Name)
+	check("profile", c.Expect.Profile, cfg.Profile)
+	check("url", c.Expect.URL, cfg.URL)
+
+	return outcome
+}
+
+// WriteReport prints a pass/fail line for each outcome, with a diff for the
+// failures, followed by a summary. It returns the number of failed cases.
+func WriteReport(w io.Writer, outcomes []Outcome) int {
+	failed := 0
+	for _, o := range outcomes {
+		label := o.Case.URL
+		if o.Case.Name != "" {
+			label = fmt.Sprintf("%s (%s)", o.Case.Name, o.Case.URL)
+		}
+
+		if o.Passed() {
+			fmt.Fprintf(w, "PASS  %s\n", label)
+			continue
+		}
+
+		failed++
+		fmt.Fprintf(w, "FAIL  %s\n", label)
+		fmt.Fprintf(w, "      from %s\n", o.Case.Source)
+		if o.Error != "" {
+			fmt.Fprintf(w, "      error: %s\n", o.Error)
+		}
+		for _, d := range o.Diffs {
+			fmt.Fprintf(w, "      %s: expected %q, got %q\n", d.Field, d.Expected, d.Actual)
+		}
+	}
+
+	fmt.Fprintf(w, "\n%d passed, %d failed\n", len(outcomes)-failed, failed)
+	return failed
+}
@@ -0,0 +1,114 @@
+package configtest_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "finicky/configtest"
+	"finicky/internal/testvm"
+)
+
+func TestSiblingPath(t *testing.T) {
+	cases := []struct {
+		input string
+		want  string
+	}{
+		{"/Users/me/.finicky.js", "/Users/me/.finicky.test.json"},
+		{"/Users/me/.config/finicky/finicky.ts", "/Users/me/.config/finicky/finicky.test.json"},
+		{"/Users/me/Library/Application Support/Finicky/rules.json", "/Users/me/Library/Application Support/Finicky/rules.test.json"},
+	}
+	for _, c := range cases {
+		if got := SiblingPath(c.input); got != c.want {
+			t.Errorf("SiblingPath(%q) = %q, want %q", c.input, got, c.want)
+		}
+	}
+}
+
+func TestLoadFile_MissingFile(t *testing.T) {
+	cases, err := LoadFile(filepath.Join(t.TempDir(), "missing.test.json"))
+	if err != nil {
+		t.Errorf("expected nil error for missing file, got %v", err)
+	}
+	if len(cases) != 0 {
+		t.Errorf("expected no cases, got %d", len(cases))
+	}
+}
+
+func TestLoadFile_MissingURL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "finicky.test.json")
+	if err := os.WriteFile(path, []byte(`[{"expect": {"browser": "Safari"}}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadFile(path); err == nil {
+		t.Error("expected error for case without url")
+	}
+}
+
+func TestDiscoverAndRun(t *testing.T) {
+	vm := testvm.JS(t, `({
+		defaultBrowser: "Safari",
+		handlers: [
+			{ match: "*github.com/*", browser: { name: "Google Chrome", profile: "Work" } },
+			{
+				match: (url, { opener }) => opener && opener.bundleId === "com.tinyspeck.slackmacgap",
+				browser: "Firefox"
+			}
+		],
+		tests: [
+			{ name: "github", url: "https://github.com/foo", expect: { browser: "Google Chrome", profile: "Work" } },
+			{ url: "https://example.com", opener: { bundleId: "com.tinyspeck.slackmacgap" }, expect: { browser: "Firefox" } }
+		]
+	})`)
+
+	configPath := filepath.Join(t.TempDir(), "finicky.js")
+	sibling := `[
+		{ "url": "https://example.com", "expect": { "browser": "Safari", "profile": "" } },
+		{ "name": "wrong", "url": "https://github.com/bar", "expect": { "browser": "Safari", "profile": "Work" } }
+	]`
+	if err := os.WriteFile(SiblingPath(configPath), []byte(sibling), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases, err := Discover(vm, configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cases) != 4 {
+		t.Fatalf("expected 4 cases, got %d", len(cases))
+	}
+	if cases[0].Source != "config" || cases[3].Source != SiblingPath(configPath) {
+		t.Errorf("unexpected sources: %q, %q", cases[0].Source, cases[3].Source)
+	}
+
+	outcomes := Run(vm, cases)
+	for i := 0; i < 3; i++ {
+		if !outcomes[i].Passed() {
+			t.Errorf("case %d: expected pass, got error %q diffs %+v", i, outcomes[i].Error, outcomes[i].Diffs)
+		}
+	}
+	if outcomes[3].Passed() {
+		t.Fatal("case 3: expected failure")
+	}
+	if len(outcomes[3].Diffs) != 1 || outcomes[3].Diffs[0].Field != "browser" {
+		t.Errorf("case 3: unexpected diffs %+v", outcomes[3].Diffs)
+	}
+
+	var report bytes.Buffer
+	if failed := WriteReport(&report, outcomes); failed != 1 {
+		t.Errorf("failed: got %d, want 1", failed)
+	}
+	out := report.String()
+	for _, want := range []string{
+		"PASS  github (https://github.com/foo)",
+		"FAIL  wrong (https://github.com/bar)",
+		`browser: expected "Safari", got "Google Chrome"`,
+		"3 passed, 1 failed",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("report missing %q:\n%s", want, out)
+		}
+	}
+}
@@ -9,6 +9,7 @@ require (
 	github.com/evanw/esbuild v0.24.2
 	github.com/fsnotify/fsnotify v1.8.0
 	github.com/jvatic/goja-babel v0.0.0-20250308121736-c08d87dbdc10
+	golang.org/x/net v0.39.0
 )
 
 require (
@@ -0,0 +1,55 @@
+// Package testvm creates config VMs for tests from inline configs.
+package testvm
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"finicky/config"
+	"finicky/rules"
+)
+
+// APIContent reads the bundled config API, assets/finickyConfigAPI.js, which
+// is built from packages/config-api.
+func APIContent(t testing.TB) []byte {
+	t.Helper()
+	_, file, _, _ := runtime.Caller(0)
+	path := filepath.Join(filepath.Dir(file), "../../assets/finickyConfigAPI.js")
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to load finickyConfigAPI.js: %v", err)
+	}
+	return b
+}
+
+// JS creates a VM from an inline JS config object literal. NewFromScript
+// bypasses esbuild bundling, so the config is assigned to a var, which the
+// config API accepts directly in goja. The VM is marked as a JS config VM to
+// exercise the merge with JSON rules.
+func JS(t testing.TB, configObj string) *config.VM {
+	t.Helper()
+	vm, err := config.NewFromScript(APIContent(t), "finickyConfig", "var finickyConfig = "+configObj)
+	if err != nil {
+		t.Fatalf("failed to create VM from JS: %v", err)
+	}
+	vm.SetIsJSConfig(true)
+	return vm
+}
+
+// Rules creates a VM that evaluates a RulesFile with the config API. The app
+// uses config.NewFromRules instead; Rules is the reference it's checked
+// against.
+func Rules(t testing.TB, rf rules.RulesFile) *config.VM {
+	t.Helper()
+	script, err := rules.ToJSConfigScript(rf, "finickyConfig")
+	if err != nil {
+		t.Fatalf("failed to generate JS config from rules: %v", err)
+	}
+	vm, err := config.NewFromScript(APIContent(t), "finickyConfig", script)
+	if err != nil {
+		t.Fatalf("failed to create VM from rules: %v", err)
+	}
+	return vm
+}
@@ -15,6 +15,10 @@ import (
 var memLog *bytes.Buffer
 var file *os.File
 
+// console is where log output is echoed besides the window and log file.
+var console io.Writer = os.Stdout
+var level slog.Level = slog.LevelDebug
+
 // windowWriter implements io.Writer to send logs to the window
 type windowWriter struct{}
 
@@ -32,7 +36,7 @@ func (w *windowWriter) Write(p []byte) (n int, err error) {
 // createHandler creates a slog handler with the given writer
 func createHandler(writer io.Writer) slog.Handler {
 	return slog.NewJSONHandler(writer, &slog.HandlerOptions{
-		Level:     slog.LevelDebug,
+		Level:     level,
 		AddSource: false,
 		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
 			// Format time as ISO string with microseconds
@@ -51,12 +55,20 @@ func createHandler(writer io.Writer) slog.Handler {
 func Setup() {
 	// Start with in-memory logging
 	memLog = &bytes.Buffer{}
-	multiWriter := io.MultiWriter(memLog, os.Stdout, &windowWriter{})
+	multiWriter := io.MultiWriter(memLog, console, &windowWriter{})
 
 	// Set the default logger
 	slog.SetDefault(slog.New(createHandler(multiWriter)))
 }
 
+// SetupCLI moves console logging to stderr and drops debug output, so that
+// headless commands can print machine-readable results on stdout.
+func SetupCLI() {
+	console = os.Stderr
+	level = slog.LevelInfo
+	slog.SetDefault(slog.New(createHandler(io.MultiWriter(memLog, console))))
+}
+
 // SetupFile configures file logging if enabled
 func SetupFile(shouldLog bool) error {
 	slog.Debug("Setting up file logging", "shouldLog", shouldLog)
@@ -96,7 +108,7 @@ func SetupFile(shouldLog bool) error {
 	}
 
 	// Update writer to include file while preserving window writer
-	multiWriter := io.MultiWriter(file, memLog, os.Stdout, &windowWriter{})
+	multiWriter := io.MultiWriter(file, memLog, console, &windowWriter{})
 
 	// Update the default logger
 	slog.SetDefault(slog.New(createHandler(multiWriter)))
@@ -11,6 +11,7 @@ import "C"
 import (
 	_ "embed"
 	"encoding/base64"
+	"errors"
 	"finicky/browser"
 	"finicky/config"
 	"finicky/logger"
@@ -26,8 +27,6 @@ import (
 	"runtime"
 	"strings"
 	"time"
-
-	"github.com/dop251/goja"
 )
 
 //go:embed assets/finickyConfigAPI.js
@@ -53,7 +52,10 @@ type ConfigInfo struct {
 
 var urlListener chan URLInfo = make(chan URLInfo)
 var windowClosed chan struct{} = make(chan struct{})
-var vm *config.VM
+
+// vmWorker owns the active config VM. All evaluation goes through it so that
+// the event loop and test URLs from the window never use the runtime at once.
+var vmWorker = config.NewWorker()
 
 var forceWindowOpen bool = false
 var queueWindowOpen chan bool = make(chan bool)
@@ -77,6 +79,12 @@ func main() {
 	dryRunPtr := flag.Bool("dry-run", false, "Simulate without actually opening browsers")
 	flag.Parse()
 
+	// Subcommands run headless and print their results on stdout.
+	command := flag.Args()
+	if len(command) > 0 {
+		logger.SetupCLI()
+	}
+
 	// Use the parsed values
 	customConfigPath := *configPathPtr
 	if customConfigPath != "" {
@@ -99,6 +107,12 @@ func main() {
 
 	dryRun = *dryRunPtr
 
+	namespace := "finickyConfig"
+
+	if len(command) > 0 {
+		os.Exit(runCommand(command, customConfigPath, namespace))
+	}
+
 	currentVersion := version.GetCurrentVersion()
 	commitHash, buildDate := version.GetBuildInfo()
 	slog.Info("Starting Finicky", "version", currentVersion)
@@ -115,7 +129,6 @@ func main() {
 		}
 	}()
 
-	namespace := "finickyConfig"
 	configChange := make(chan struct{}, 1)
 	cfw, err := config.NewConfigFileWatcher(customConfigPath, namespace, configChange)
 
@@ -123,10 +136,13 @@ func main() {
 		handleFatalError(fmt.Sprintf("Failed to setup config file watcher: %v", err))
 	}
 
-	vm, err = setupVM(cfw, namespace)
+	// There is no earlier config to keep, so the rules apply even when the
+	// config fails to load.
+	initialVM, initialRules, err := setupVM(cfw, namespace)
 	if err != nil {
-		handleFatalError(err.Error())
+		handleConfigError(err)
 	}
+	commitVM(initialVM, initialRules)
 
 	slog.Debug("VM setup complete", "duration", fmt.Sprintf("%.2fms", float64(time.Since(startTime).Microseconds())/1000))
 
@@ -143,26 +159,14 @@ func main() {
 	window.SaveRulesHandler = func(rf rules.RulesFile) {
 		slog.Debug("Rules updated", "count", len(rf.Rules))
 		resolver.SetCachedRules(rf)
-		if vm == nil || !vm.IsJSConfig() {
+		if !vmWorker.IsJSConfig() {
 			if rf.DefaultBrowser == "" && len(rf.Rules) == 0 && rf.Options == nil {
-				vm = nil
-				return
-			}
-			script, err := rules.ToJSConfigScript(rf, namespace)
-			if err != nil {
-				slog.Error("Failed to generate config from rules", "error", err)
+				vmWorker.Swap(nil)
 				return
 			}
-			newVM, err := config.NewFromScript(finickyConfigAPIJS, namespace, script)
-			if err != nil {
-				slog.Error("Failed to rebuild VM from rules", "error", err)
-				return
-			}
-			vm = newVM
-			if vm != nil {
-				shouldKeepRunning = vm.GetAllConfigOptions().KeepRunning
-				go checkForUpdates()
-			}
+			vmWorker.Swap(config.NewFromRules(rf))
+			shouldKeepRunning = vmWorker.GetAllConfigOptions().KeepRunning
+			go checkForUpdates()
 		}
 	}
 
@@ -172,8 +176,8 @@ func main() {
 	timeoutChan := time.After(1 * time.Second)
 	updateChan := time.After(oneDay)
 
-	if vm != nil {
-		shouldKeepRunning = vm.GetAllConfigOptions().KeepRunning
+	if initialVM != nil {
+		shouldKeepRunning = vmWorker.GetAllConfigOptions().KeepRunning
 	}
 	if shouldKeepRunning {
 		timeoutChan = nil
@@ -190,7 +194,7 @@ func main() {
 
 				slog.Info("URL received", "url", url)
 
-				config, err := resolver.ResolveURL(vm, url, urlInfo.Opener, urlInfo.OpenInBackground)
+				config, err := resolveURL(url, urlInfo.Opener, urlInfo.OpenInBackground)
 				if err != nil {
 					handleRuntimeError(err)
 				} else {
@@ -210,18 +214,19 @@ func main() {
 
 			case <-configChange:
 				startTime := time.Now()
-				var setupErr error
 				slog.Debug("Config has changed")
-				vm, setupErr = setupVM(cfw, namespace)
+				// Keep routing with the last good config until the new one loads
+				newVM, newRules, setupErr := setupVM(cfw, namespace)
 				if setupErr != nil {
-					handleRuntimeError(setupErr)
+					handleConfigError(setupErr)
 				} else {
+					commitVM(newVM, newRules)
 					lastError = nil
 					C.SetStatusItemError(false)
 				}
 				slog.Debug("VM refresh complete", "duration", fmt.Sprintf("%.2fms", float64(time.Since(startTime).Microseconds())/1000))
-				if vm != nil {
-					shouldKeepRunning = vm.GetAllConfigOptions().KeepRunning
+				if setupErr == nil && newVM != nil {
+					shouldKeepRunning = vmWorker.GetAllConfigOptions().KeepRunning
 					go checkForUpdates()
 				}
 
@@ -252,14 +257,29 @@ func main() {
 	}()
 
 	shouldHideIcon := false
-	if vm != nil {
-		shouldHideIcon = vm.GetAllConfigOptions().HideIcon
+	if initialVM != nil {
+		shouldHideIcon = vmWorker.GetAllConfigOptions().HideIcon
 	}
 	C.RunApp(C.bool(forceWindowOpen), C.bool(!shouldHideIcon), C.bool(shouldKeepRunning))
 }
 
+// resolveURL evaluates a URL against the active VM on the VM worker.
+func resolveURL(url string, opener *resolver.OpenerInfo, openInBackground bool) (*browser.BrowserConfig, error) {
+	var cfg *browser.BrowserConfig
+	var err error
+	vmWorker.Do(func(vm *config.VM) {
+		cfg, err = resolver.ResolveURL(vm, url, opener, openInBackground)
+	})
+	return cfg, err
+}
+
 func handleRuntimeError(err error) {
-	slog.Error("Failed evaluating url", "error", err)
+	var fallbackErr *resolver.FallbackError
+	if errors.As(err, &fallbackErr) {
+		slog.Error("Failed evaluating url", "error", err, "fallback", fallbackErr.Fallback)
+	} else {
+		slog.Error("Failed evaluating url", "error", err)
+	}
 	lastError = err
 	C.SetStatusItemError(true)
 }
@@ -308,22 +328,46 @@ func TestURL(url *C.char) {
 func TestURLInternal(urlString string) {
 	slog.Debug("Testing URL", "url", urlString)
 
-	config, err := resolver.ResolveURL(vm, urlString, nil, false)
+	var cfg *browser.BrowserConfig
+	var trace *resolver.Trace
+	var err error
+	vmWorker.Do(func(vm *config.VM) {
+		cfg, trace, err = resolver.Explain(vm, urlString, nil, false)
+	})
+
+	result := map[string]interface{}{
+		"url":              cfg.URL,
+		"browser":          cfg.Name,
+		"openInBackground": cfg.OpenInBackground,
+		"profile":          cfg.Profile,
+		"args":             cfg.Args,
+		"trace":            trace,
+	}
 	if err != nil {
-		slog.Error("Failed to evaluate URL", "error", err)
-		window.SendMessageToWebView("testUrlResult", map[string]interface{}{
-			"error": err.Error(),
-		})
-		return
+		slog.Error("Failed to evaluate URL", "error", err, "fallback", trace.Fallback)
+		result["error"] = err.Error()
 	}
+	window.SendMessageToWebView("testUrlResult", result)
+}
 
-	window.SendMessageToWebView("testUrlResult", map[string]interface{}{
-		"url":              config.URL,
-		"browser":          config.Name,
-		"openInBackground": config.OpenInBackground,
-		"profile":          config.Profile,
-		"args":             config.Args,
-	})
+// handleConfigError reports a config that failed to bundle, evaluate or
+// validate. The previously active VM, if any, stays in place.
+func handleConfigError(err error) {
+	slog.Error("Failed to load config", "error", err)
+	lastError = err
+	C.SetStatusItemError(true)
+
+	message := map[string]interface{}{
+		"message": err.Error(),
+	}
+	var cfgErr *config.ConfigError
+	if errors.As(err, &cfgErr) {
+		message["message"] = cfgErr.Message
+		message["file"] = cfgErr.File
+		message["line"] = cfgErr.Line
+		message["column"] = cfgErr.Column
+	}
+	window.SendMessageToWebView("configError", message)
 }
 
 func handleFatalError(errorMessage string) {
@@ -364,15 +408,8 @@ func GetCurrentConfigPath() *C.char {
 }
 
 func checkForUpdates() {
-	var runtime *goja.Runtime
-	if vm != nil {
-		runtime = vm.Runtime()
-	}
-
-	releaseInfo, updateCheckEnabled, err := version.CheckForUpdatesIfEnabled(runtime)
-	if err != nil {
-		slog.Error("Error checking for updates", "error", err)
-	}
+	opts := vmWorker.GetAllConfigOptions()
+	releaseInfo, updateCheckEnabled := version.CheckForUpdatesIfEnabled(opts.CheckForUpdates)
 
 	updateInfo = UpdateInfo{
 		ReleaseInfo:        releaseInfo,
@@ -408,7 +445,20 @@ func tearDown() {
 	os.Exit(0)
 }
 
-func setupVM(cfw *config.ConfigFileWatcher, namespace string) (*config.VM, error) {
+// commitVM routes with vm and the JSON rules loaded with it. rf is nil when
+// the rules couldn't be loaded, keeping the ones in use.
+func commitVM(vm *config.VM, rf *rules.RulesFile) {
+	if rf != nil {
+		resolver.SetCachedRules(*rf)
+	}
+	vmWorker.Swap(vm)
+}
+
+// setupVM builds a VM from the config on disk, along with the JSON rules to
+// merge into it. The rules are returned even when building the VM fails, and
+// are nil when they can't be loaded; the caller commits them to the resolver
+// together with the VM.
+func setupVM(cfw *config.ConfigFileWatcher, namespace string) (*config.VM, *rules.RulesFile, error) {
 	logRequests := true
 	var err error
 
@@ -419,72 +469,59 @@ func setupVM(cfw *config.ConfigFileWatcher, namespace string) (*config.VM, error
 		}
 	}()
 
+	// Load the rules up front so that JSON rules apply as soon as they are
+	// committed, even when a JS config is also present.
+	var loadedRules *rules.RulesFile
+	if rf, rulesErr := rules.Load(); rulesErr != nil {
+		slog.Warn("Failed to load rules file", "error", rulesErr)
+	} else {
+		loadedRules = &rf
+	}
+
 	var currentBundlePath, configPath string
 	if !skipJSConfig {
 		var err2 error
 		currentBundlePath, configPath, err2 = cfw.BundleConfig()
 		if err2 != nil {
-			return nil, fmt.Errorf("failed to read config: %v", err2)
+			return nil, loadedRules, config.WithConfigPath(fmt.Errorf("failed to read config: %w", err2), configPath)
 		}
 	}
 
-	// Always seed the cached rules from disk so JSON rules are applied
-	// immediately on startup, even when a JS config is also present.
-	if rf, rulesErr := rules.Load(); rulesErr != nil {
-		slog.Warn("Failed to pre-load rules cache", "error", rulesErr)
-	} else {
-		resolver.SetCachedRules(rf)
-	}
-
 	var newVM *config.VM
 
 	if currentBundlePath != "" {
 		newVM, err = config.New(finickyConfigAPIJS, namespace, currentBundlePath)
 		if err != nil {
-			return nil, fmt.Errorf("failed to setup VM: %v", err)
-		}
-	} else {
-		rf, rulesErr := rules.Load()
-		if rulesErr != nil {
-			slog.Warn("Failed to load rules file", "error", rulesErr)
-		} else {
-			resolver.SetCachedRules(rf)
-			if rf.DefaultBrowser != "" || len(rf.Rules) > 0 {
-				script, scriptErr := rules.ToJSConfigScript(rf, namespace)
-				if scriptErr != nil {
-					return nil, fmt.Errorf("failed to generate config from rules: %v", scriptErr)
-				}
-				newVM, err = config.NewFromScript(finickyConfigAPIJS, namespace, script)
-				if err != nil {
-					return nil, fmt.Errorf("failed to setup VM from rules: %v", err)
-				}
-				configPath, _ = rules.GetPath()
-			}
+			return nil, loadedRules, config.WithConfigPath(err, configPath)
 		}
+	} else if loadedRules != nil && (loadedRules.DefaultBrowser != "" || len(loadedRules.Rules) > 0) {
+		newVM = config.NewFromRules(*loadedRules)
+		configPath, _ = rules.GetPath()
 	}
 
 	if newVM == nil {
-		return nil, nil
+		return nil, loadedRules, nil
 	}
 
-	cs := newVM.GetConfigState()
-	if cs != nil {
-		configInfo = &ConfigInfo{
-			Handlers:       cs.Handlers,
-			Rewrites:       cs.Rewrites,
-			DefaultBrowser: cs.DefaultBrowser,
-			ConfigPath:     configPath,
-		}
+	// The state is nil when reading it fails, such as when it runs over the
+	// evaluation budget; the counts are then reported as zero rather than
+	// those of the previous config.
+	info := &ConfigInfo{ConfigPath: configPath}
+	if cs := newVM.GetConfigState(); cs != nil {
+		info.Handlers = cs.Handlers
+		info.Rewrites = cs.Rewrites
+		info.DefaultBrowser = cs.DefaultBrowser
 	}
+	configInfo = info
 
 	opts := newVM.GetAllConfigOptions()
 	logRequests = opts.LogRequests
 
 	window.SendMessageToWebView("config", map[string]interface{}{
-		"handlers":       configInfo.Handlers,
-		"rewrites":       configInfo.Rewrites,
-		"defaultBrowser": configInfo.DefaultBrowser,
-		"configPath":     util.ShortenPath(configInfo.ConfigPath),
+		"handlers":       info.Handlers,
+		"rewrites":       info.Rewrites,
+		"defaultBrowser": info.DefaultBrowser,
+		"configPath":     util.ShortenPath(info.ConfigPath),
 		"isJSConfig":     newVM.IsJSConfig(),
 		"options": map[string]interface{}{
 			"keepRunning":     opts.KeepRunning,
@@ -494,5 +531,5 @@ func setupVM(cfw *config.ConfigFileWatcher, namespace string) (*config.VM, error
 		},
 	})
 
-	return newVM, nil
+	return newVM, loadedRules, nil
 }
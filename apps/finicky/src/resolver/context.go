@@ -0,0 +1,22 @@
+package resolver
+
+import (
+	"time"
+
+	"finicky/util"
+)
+
+// systemContext checks rules.json when conditions against the live system.
+type systemContext struct{}
+
+func (systemContext) ModifierKeys() map[string]bool {
+	return util.GetModifierKeys()
+}
+
+func (systemContext) IsAppRunning(identifier string) bool {
+	return util.IsAppRunning(identifier)
+}
+
+func (systemContext) Now() time.Time {
+	return time.Now()
+}
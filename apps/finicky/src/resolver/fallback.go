@@ -0,0 +1,86 @@
+package resolver
+
+import (
+	"finicky/browser"
+	"finicky/rules"
+)
+
+// Fallbacks used when evaluating the config fails, in the order they are
+// tried.
+const (
+	// FallbackRules is a cached JSON rule that matched the URL.
+	FallbackRules = "rules"
+	// FallbackRulesDefault is the default browser of the cached rules file.
+	FallbackRulesDefault = "rulesDefault"
+	// FallbackSystemDefault is the system default browser recorded when
+	// Finicky became the default browser.
+	FallbackSystemDefault = "systemDefault"
+	// FallbackSafari is the last resort.
+	FallbackSafari = "safari"
+)
+
+// FallbackError is returned when evaluating the config failed and the URL was
+// routed by a fallback instead.
+type FallbackError struct {
+	Err      error
+	Fallback string
+}
+
+func (e *FallbackError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *FallbackError) Unwrap() error {
+	return e.Err
+}
+
+// fallbackConfig picks a browser for the expanded URL urlStr without the
+// config: a matching cached JSON rule, the rules file's default browser, the
+// recorded system default browser, and finally Safari.
+func fallbackConfig(urlStr string, opener *OpenerInfo, openInBackground bool) (*browser.BrowserConfig, string) {
+	rf := getCachedRules()
+
+	href, err := rules.NormalizeURL(urlStr)
+	if err != nil {
+		href = urlStr
+	}
+	if target, rule := rf.Match(href, opener, systemContext{}); rule >= 0 {
+		return withBackground(targetConfig(target, urlStr), openInBackground), FallbackRules
+	}
+	if rf.DefaultBrowser != "" {
+		return withBackground(targetConfig(rf.DefaultTarget(), urlStr), openInBackground), FallbackRulesDefault
+	}
+	if bundleID := browser.GetSystemDefault(); bundleID != "" {
+		target := rules.Target{Browser: bundleID, AppType: "bundleId"}
+		return withBackground(targetConfig(target, urlStr), openInBackground), FallbackSystemDefault
+	}
+	return defaultBrowserConfig(urlStr, openInBackground), FallbackSafari
+}
+
+// targetConfig returns the browser config that opens urlStr in target, and
+// in its fallbacks when it can't.
+func targetConfig(target rules.Target, urlStr string) *browser.BrowserConfig {
+	args := target.Args
+	if args == nil {
+		args = []string{}
+	}
+	cfg := &browser.BrowserConfig{
+		Name:      target.Browser,
+		AppType:   target.AppType,
+		Profile:   target.Profile,
+		Args:      args,
+		URL:       urlStr,
+		Private:   target.Private,
+		AppWindow: target.AppWindow,
+	}
+	for _, f := range target.Fallbacks {
+		cfg.Fallbacks = append(cfg.Fallbacks, *targetConfig(f, urlStr))
+	}
+	return cfg
+}
+
+func withBackground(cfg *browser.BrowserConfig, openInBackground bool) *browser.BrowserConfig {
+	bg := openInBackground
+	cfg.OpenInBackground = &bg
+	return cfg
+}
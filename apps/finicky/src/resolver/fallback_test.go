@@ -0,0 +1,86 @@
+package resolver_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"finicky/browser"
+	"finicky/internal/testvm"
+	. "finicky/resolver"
+	"finicky/rules"
+)
+
+func TestResolveURL_FallbackTiers(t *testing.T) {
+	browser.SetSystemDefaultPath(filepath.Join(t.TempDir(), "system_default_browser.json"))
+	t.Cleanup(func() { browser.SetSystemDefaultPath("") })
+	t.Cleanup(func() { SetCachedRules(rules.RulesFile{}) })
+
+	// The failing rewrite skips all handlers, including the merged JSON rules,
+	// and the default browser can't be resolved either.
+	broken := testvm.JS(t, `({
+		defaultBrowser: (url) => undefined,
+		rewrite: [{ match: () => { throw new Error("broken rewrite") }, url: (url) => url }]
+	})`)
+
+	resolveFallback := func(url string) (string, string) {
+		t.Helper()
+		result, err := ResolveURL(broken, url, nil, false)
+		var fallbackErr *FallbackError
+		if !errors.As(err, &fallbackErr) {
+			t.Fatalf("expected FallbackError, got %v", err)
+		}
+		return result.Name, fallbackErr.Fallback
+	}
+
+	if name, fallback := resolveFallback("https://example.com"); name != "com.apple.Safari" || fallback != FallbackSafari {
+		t.Errorf("no fallbacks: got %q from %q", name, fallback)
+	}
+
+	if err := browser.RecordSystemDefault("org.mozilla.firefox"); err != nil {
+		t.Fatal(err)
+	}
+	if name, fallback := resolveFallback("https://example.com"); name != "org.mozilla.firefox" || fallback != FallbackSystemDefault {
+		t.Errorf("system default: got %q from %q", name, fallback)
+	}
+
+	SetCachedRules(rules.RulesFile{
+		DefaultBrowser: "Google Chrome",
+		Rules:          []rules.Rule{{Match: []string{"*github.com/*"}, Browser: "Firefox", Profile: "Work"}},
+	})
+	if name, fallback := resolveFallback("https://example.com"); name != "Google Chrome" || fallback != FallbackRulesDefault {
+		t.Errorf("rules default: got %q from %q", name, fallback)
+	}
+	if name, fallback := resolveFallback("https://github.com/foo"); name != "Firefox" || fallback != FallbackRules {
+		t.Errorf("rules: got %q from %q", name, fallback)
+	}
+
+	// Fallbacks match and open the unwrapped URL.
+	wrapped := "https://nam02.safelinks.protection.outlook.com/?url=https%3A%2F%2Fgithub.com%2Ffoo&data=05"
+	result, err := ResolveURL(broken, wrapped, nil, false)
+	var fallbackErr *FallbackError
+	if !errors.As(err, &fallbackErr) || fallbackErr.Fallback != FallbackRules {
+		t.Fatalf("wrapped: expected FallbackError from %q, got %v", FallbackRules, err)
+	}
+	if result.Name != "Firefox" || result.URL != "https://github.com/foo" {
+		t.Errorf("wrapped: got %q opening %q", result.Name, result.URL)
+	}
+}
+
+func TestRecordSystemDefault_KeepsFirst(t *testing.T) {
+	browser.SetSystemDefaultPath(filepath.Join(t.TempDir(), "system_default_browser.json"))
+	t.Cleanup(func() { browser.SetSystemDefaultPath("") })
+
+	if got := browser.GetSystemDefault(); got != "" {
+		t.Errorf("expected no system default, got %q", got)
+	}
+	if err := browser.RecordSystemDefault("com.apple.Safari"); err != nil {
+		t.Fatal(err)
+	}
+	if err := browser.RecordSystemDefault("org.mozilla.firefox"); err != nil {
+		t.Fatal(err)
+	}
+	if got := browser.GetSystemDefault(); got != "com.apple.Safari" {
+		t.Errorf("got %q, want %q", got, "com.apple.Safari")
+	}
+}
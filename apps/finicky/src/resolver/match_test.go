@@ -0,0 +1,192 @@
+package resolver_test
+
+import (
+	"reflect"
+	"testing"
+
+	"finicky/config"
+	"finicky/internal/testvm"
+	. "finicky/resolver"
+	"finicky/rules"
+)
+
+// TestResolveURL_RulesParity checks that matching rules in Go gives the same
+// result as evaluating them with the config API.
+func TestResolveURL_RulesParity(t *testing.T) {
+	rulesFiles := []rules.RulesFile{
+		{
+			DefaultBrowser: "Firefox",
+			Rules: []rules.Rule{
+				{Match: []string{"*github.com/*"}, Browser: "Google Chrome"},
+				{Match: []string{"https://linear.app/*"}, Browser: "Safari"},
+			},
+		},
+		{
+			DefaultBrowser: "Safari",
+			Rules: []rules.Rule{
+				{Match: []string{"*github.com/*"}, Browser: "Google Chrome", Profile: "Work"},
+			},
+		},
+		{
+			DefaultBrowser: "com.google.Chrome",
+			DefaultProfile: "Personal",
+			Rules: []rules.Rule{
+				{Match: []string{""}, Browser: "Firefox"},
+				{Match: []string{"example.com/*", "*.example.org/*"}, Browser: "Firefox:Dev"},
+				{Match: []string{"https://*.atlassian.net/*"}, Browser: "/Applications/Arc.app"},
+				{Match: []string{"mailto:*"}, Browser: ""},
+				{Match: []string{`https://example.net/\**`}, Browser: "org.mozilla.firefox"},
+			},
+		},
+		{
+			DefaultBrowser: "Safari",
+			Rewrites: []rules.Rewrite{
+				{Match: []string{"*example.com/*"}, RemoveQuery: []string{"q"}, SetQuery: map[string]string{"ref": "finicky"}},
+				{Match: []string{"http://*"}, ForceHTTPS: true},
+				{Match: []string{"*linear.app/*"}, Host: "github.com"},
+				{Match: []string{""}, Host: "example.invalid"},
+				{Match: []string{"*atlassian.net/*"}, Replace: &rules.RegexReplace{Pattern: `/browse/([A-Z]+)-\d+`, With: "/projects/$1"}},
+			},
+			Rules: []rules.Rule{
+				{Match: []string{"*github.com/*"}, Browser: "Google Chrome"},
+				{Match: []string{"*ref=finicky*"}, Browser: "Firefox"},
+			},
+		},
+		{
+			DefaultBrowser: "Safari",
+			Rules: []rules.Rule{
+				{Match: []string{"*github.com/*"}, Browser: "com.google.Chrome", Private: true},
+				{Match: []string{"*linear.app/*"}, Browser: "Google Chrome:Work", AppWindow: true},
+				{Match: []string{"example.com/*"}, Browser: "Firefox", Profile: "Dev", Private: true},
+				{Match: []string{"*.example.org/*"}, Browser: "Visual Studio Code", Args: []string{"--goto", "{path}"}},
+				{Match: []string{"*atlassian.net/*"}, Browser: "Arc", Fallbacks: []string{"com.google.Chrome", "", "Firefox:Work"}},
+				{Match: []string{"mailto:*"}, Browser: "Google Chrome", Private: true, Fallbacks: []string{"Safari"}},
+			},
+		},
+		{
+			DefaultBrowser: "Safari",
+			Rules: []rules.Rule{
+				{Match: []string{"https://*//"}, Browser: "Firefox"},
+				{Match: []string{"file:///*"}, Browser: "Google Chrome"},
+				{Match: []string{"*sale-50%-off*"}, Browser: "Firefox"},
+				{Match: []string{"https://*"}, Browser: "Arc"},
+			},
+		},
+		{},
+	}
+	// NormalizeURL is also checked against WHATWG URL.href in the rules
+	// package, for URLs the config API's polyfill is needed for.
+	urls := []string{
+		"https://github.com/johnste/finicky",
+		"https://linear.app/team/issue/123",
+		"https://example.com",
+		"http://EXAMPLE.com:80/path?q=1#frag",
+		"https://www.example.org/x",
+		"https://example.org/x",
+		"https://team.atlassian.net/browse/ABC-1",
+		"https://example.net/*literal",
+		"https://example.net/literal",
+		"mailto:someone@example.com",
+		"https://EXAMPLE.com:443/%7euser/",
+		"https://example.com//",
+		"https://example.com//x",
+		"file:///Users/me/notes.txt",
+		"https://example.com/sale-50%-off",
+		"https://example.com/50%/x?a=b",
+	}
+
+	for _, rf := range rulesFiles {
+		jsVM := testvm.Rules(t, rf)
+		nativeVM := config.NewFromRules(rf)
+		for _, url := range urls {
+			for _, background := range []bool{false, true} {
+				want, wantErr := ResolveURL(jsVM, url, nil, background)
+				got, gotErr := ResolveURL(nativeVM, url, nil, background)
+				if (wantErr != nil) != (gotErr != nil) {
+					t.Errorf("%+v %s: error mismatch: JS %v, Go %v", rf, url, wantErr, gotErr)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("%+v %s:\n  Go: %+v\n  JS: %+v", rf, url, *got, *want)
+				}
+			}
+		}
+	}
+}
+
+func TestExplain_NativeRules(t *testing.T) {
+	vm := config.NewFromRules(rules.RulesFile{
+		DefaultBrowser: "Firefox",
+		Rules: []rules.Rule{
+			{Match: []string{""}, Browser: "Safari"},
+			{Match: []string{"*github.com/*"}, Browser: "Google Chrome"},
+		},
+	})
+
+	_, trace, err := Explain(vm, "https://github.com/foo", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trace.Steps) != 1 || trace.Winner == nil || trace.Winner.Index != 1 || trace.Winner.Source != SourceRules {
+		t.Errorf("unexpected trace: %+v", trace)
+	}
+
+	_, trace, _ = Explain(vm, "https://example.com", nil, false)
+	if trace.Winner == nil || trace.Winner.Kind != "defaultBrowser" {
+		t.Errorf("expected default browser to win, got %+v", trace.Winner)
+	}
+}
+
+func TestResolveURL_RulesOpenerCondition(t *testing.T) {
+	rf := rules.RulesFile{
+		DefaultBrowser: "Safari",
+		Rules: []rules.Rule{
+			{Match: []string{"*example.com/*"}, Browser: "Firefox", Opener: &rules.OpenerCondition{BundleID: "com.apple.Terminal"}},
+		},
+	}
+	terminal := &OpenerInfo{Name: "Terminal", BundleID: "com.apple.Terminal"}
+	finder := &OpenerInfo{Name: "Finder", BundleID: "com.apple.finder"}
+
+	SetCachedRules(rf)
+	t.Cleanup(func() { SetCachedRules(rules.RulesFile{}) })
+	merged := testvm.JS(t, `({ defaultBrowser: "Safari" })`)
+
+	for _, vm := range []*config.VM{config.NewFromRules(rf), merged} {
+		for _, c := range []struct {
+			opener *OpenerInfo
+			want   string
+		}{{terminal, "Firefox"}, {finder, "Safari"}, {nil, "Safari"}} {
+			result, err := ResolveURL(vm, "https://example.com/", c.opener, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Name != c.want {
+				t.Errorf("JS config %v, opener %+v: got %q, want %q", vm.IsJSConfig(), c.opener, result.Name, c.want)
+			}
+		}
+	}
+}
+
+func TestResolveURL_JSONRewritesRunAfterJSRewrites(t *testing.T) {
+	SetCachedRules(rules.RulesFile{
+		Rewrites: []rules.Rewrite{
+			{Match: []string{"*example.org/*"}, RemoveQuery: []string{"utm_*"}, SetQuery: map[string]string{"via": "json"}},
+		},
+	})
+	t.Cleanup(func() { SetCachedRules(rules.RulesFile{}) })
+
+	vm := testvm.JS(t, `({
+		defaultBrowser: "Safari",
+		rewrite: [{ match: "*example.com/*", url: (url) => url.href.replace("example.com", "example.org") }]
+	})`)
+
+	result, trace, err := Explain(vm, "https://example.com/?utm_source=x&id=1", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "https://example.org/?id=1&via=json"; result.URL != want {
+		t.Errorf("got %q, want %q", result.URL, want)
+	}
+	if len(trace.Steps) < 2 || trace.Steps[1].Kind != "rewrite" || trace.Steps[1].Source != SourceRules || trace.Steps[1].Index != 0 {
+		t.Errorf("unexpected trace steps: %+v", trace.Steps)
+	}
+}
@@ -2,23 +2,22 @@ package resolver
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"sync"
+	"time"
 
 	"finicky/browser"
 	"finicky/config"
 	"finicky/rules"
 	"finicky/shorturl"
+
+	"github.com/dop251/goja"
 )
 
 // OpenerInfo describes the process that triggered the URL open.
-type OpenerInfo struct {
-	Name        string `json:"name"`
-	BundleID    string `json:"bundleId"`
-	Path        string `json:"path"`
-	WindowTitle string `json:"windowTitle,omitempty"`
-}
+type OpenerInfo = rules.Opener
 
 var (
 	cachedRulesMu   sync.Mutex
@@ -41,21 +40,45 @@ func getCachedRules() rules.RulesFile {
 
 // ResolveURL determines which browser to use for the given URL.
 //
-// vm may be nil (no configuration at all). Whether to merge JSON rules is
-// derived from vm.IsJSConfig().
+// vm may be nil (no configuration at all). A VM created with
+// config.NewFromRules is matched in Go without evaluating JavaScript. Whether
+// to merge JSON rules is derived from vm.IsJSConfig().
 //
-// Always returns a non-nil config. Returns a non-nil error only when JS
-// evaluation failed.
+// Always returns a non-nil config. Returns a non-nil error only when
+// evaluation failed, as a *FallbackError naming the fallback that picked the
+// browser instead.
 func ResolveURL(vm *config.VM, urlStr string, opener *OpenerInfo, openInBackground bool) (*browser.BrowserConfig, error) {
-	if vm != nil {
-		cfg, err := evaluateURL(vm, urlStr, opener)
-		if err != nil {
-			return defaultBrowserConfig(urlStr, openInBackground), err
+	return resolve(vm, urlStr, opener, openInBackground, nil)
+}
+
+// resolve implements ResolveURL, recording the decision in trace when it is
+// non-nil.
+func resolve(vm *config.VM, urlStr string, opener *OpenerInfo, openInBackground bool, trace *Trace) (*browser.BrowserConfig, error) {
+	if vm == nil {
+		return defaultBrowserConfig(urlStr, openInBackground), nil
+	}
+
+	// Expand before routing, so that a fallback opens the same URL the
+	// config would have been given.
+	resolvedURL, chain := expandURL(vm, urlStr, trace)
+	var cfg *browser.BrowserConfig
+	var err error
+	if vm.Rules() != nil {
+		cfg, err = matchRules(vm, resolvedURL, opener, trace)
+	} else {
+		cfg, err = evaluateURL(vm, resolvedURL, chain, opener, trace)
+	}
+
+	if err != nil {
+		fallbackCfg, fallback := fallbackConfig(resolvedURL, opener, openInBackground)
+		slog.Warn("Using fallback browser", "fallback", fallback, "browser", fallbackCfg.Name, "profile", fallbackCfg.Profile)
+		if trace != nil {
+			trace.Fallback = fallback
 		}
-		cfg.OpenInBackground = mergeBackground(cfg.OpenInBackground, openInBackground)
-		return cfg, nil
+		return fallbackCfg, &FallbackError{Err: err, Fallback: fallback}
 	}
-	return defaultBrowserConfig(urlStr, openInBackground), nil
+	cfg.OpenInBackground = mergeBackground(cfg.OpenInBackground, openInBackground)
+	return cfg, nil
 }
 
 func mergeBackground(fromConfig *bool, requested bool) *bool {
@@ -65,16 +88,125 @@ func mergeBackground(fromConfig *bool, requested bool) *bool {
 	return &requested
 }
 
-func evaluateURL(vm *config.VM, url string, opener *OpenerInfo) (*browser.BrowserConfig, error) {
-	runtime := vm.Runtime()
+// expandURL finds the destination of url: redirect wrappers are unwrapped
+// offline, then a short URL is expanded, and then anything it expanded to is
+// unwrapped again. It returns the destination and the redirect chain from url
+// to the destination, and records the expansion in trace when it is non-nil.
+// Unwrapped URLs have no status in the chain, as they weren't requested.
+// Expansions are cached in the shared short URL cache.
+func expandURL(vm *config.VM, url string, trace *Trace) (string, []shorturl.Hop) {
+	expandStart := time.Now()
+	chain := []shorturl.Hop{{URL: url}}
+
+	unwrappedURL, unwrapped := shorturl.Unwrap(url)
+	chain = appendUnwrapped(chain, unwrapped)
+
+	resolvedURL, hops, err := shorturl.ExpandCached(unwrappedURL, vm.URLShorteners(), shorturl.DefaultCache(), vm.ShortURLCacheTTL())
+	if err != nil {
+		slog.Info("Failed to resolve short URL", "error", err, "url", unwrappedURL, "using", resolvedURL)
+	}
+	if len(hops) > 0 {
+		// The expansion starts with the URL the chain ends with.
+		chain = append(chain[:len(chain)-1], hops...)
+	}
+
+	if resolvedURL != unwrappedURL {
+		var more []string
+		resolvedURL, more = shorturl.Unwrap(resolvedURL)
+		chain = appendUnwrapped(chain, more)
+		unwrapped = append(unwrapped, more...)
+	}
+
+	if len(chain) > 1 {
+		slog.Info("Followed redirect chain", "chain", chain)
+	}
+	if trace != nil {
+		trace.ShortURL = ShortURLTrace{
+			Original:   url,
+			Resolved:   resolvedURL,
+			Unwrapped:  unwrapped,
+			DurationMs: sinceMs(expandStart),
+		}
+		if len(chain) > 1 {
+			trace.ShortURL.Chain = chain
+		}
+		if err != nil {
+			trace.ShortURL.Error = err.Error()
+		}
+	}
+	return resolvedURL, chain
+}
+
+func appendUnwrapped(chain []shorturl.Hop, unwrapped []string) []shorturl.Hop {
+	for _, u := range unwrapped {
+		chain = append(chain, shorturl.Hop{URL: u})
+	}
+	return chain
+}
 
-	resolvedURL, err := shorturl.ResolveURL(url)
-	runtime.Set("originalUrl", url)
+// matchRules routes an expanded URL with the rules of a rules-only config,
+// without evaluating any JavaScript.
+func matchRules(vm *config.VM, url string, opener *OpenerInfo, trace *Trace) (*browser.BrowserConfig, error) {
+	rf := *vm.Rules()
+	href, err := rules.NormalizeURL(url)
 	if err != nil {
-		slog.Info("Failed to resolve short URL", "error", err, "url", url, "using", resolvedURL)
+		return nil, err
 	}
-	url = resolvedURL
-	runtime.Set("url", resolvedURL)
+
+	for i, rw := range rf.Rewrites {
+		stepStart := time.Now()
+		matched := rw.Matches(href)
+		if matched {
+			rewritten, err := rw.Apply(href)
+			if err != nil {
+				return nil, fmt.Errorf("rewrite %d: %v", i, err)
+			}
+			if href, err = rules.NormalizeURL(rewritten); err != nil {
+				return nil, fmt.Errorf("rewrite %d: %v", i, err)
+			}
+		}
+		if trace != nil {
+			step := TraceStep{Kind: "rewrite", Index: i, Source: SourceRules, Matched: matched, DurationMs: sinceMs(stepStart)}
+			if matched {
+				step.URL = href
+			}
+			trace.addStep(step)
+		}
+	}
+
+	target := rf.DefaultTarget()
+	matched := false
+	for _, i := range rules.HandlerRuleIndexes(rf.Rules) {
+		stepStart := time.Now()
+		matched = rf.Rules[i].Matches(href, opener, systemContext{})
+		if trace != nil {
+			trace.addStep(TraceStep{Kind: "handler", Index: i, Source: SourceRules, Matched: matched, DurationMs: sinceMs(stepStart)})
+		}
+		if matched {
+			target = rf.Rules[i].Target()
+			break
+		}
+	}
+	if !matched && trace != nil {
+		trace.addStep(TraceStep{Kind: "defaultBrowser", Source: SourceRules, Matched: true})
+	}
+
+	slog.Debug("Matched rules", "name", target.Browser, "profile", target.Profile, "appType", target.AppType, "private", target.Private, "appWindow", target.AppWindow)
+
+	return targetConfig(target, href), nil
+}
+
+// evaluateURL routes an expanded URL with the JS config, given the redirect
+// chain expandURL followed to it.
+func evaluateURL(vm *config.VM, url string, chain []shorturl.Hop, opener *OpenerInfo, trace *Trace) (*browser.BrowserConfig, error) {
+	runtime := vm.Runtime()
+
+	if len(chain) > 1 {
+		runtime.Set("originalUrl", jsRedirectChain(chain))
+	} else {
+		runtime.Set("originalUrl", url)
+	}
+	runtime.Set("url", url)
 
 	if opener != nil {
 		openerMap := map[string]interface{}{
@@ -92,20 +224,43 @@ func evaluateURL(vm *config.VM, url string, opener *OpenerInfo) (*browser.Browse
 		slog.Debug("No opener detected")
 	}
 
-	// When there is a JS config, append cached JSON rules as lower-priority handlers.
+	var jsTrace *goja.Object
+	if trace != nil {
+		jsTrace = newJSTrace(runtime)
+		runtime.Set("_trace", jsTrace)
+	} else {
+		runtime.Set("_trace", nil)
+	}
+
+	// When there is a JS config, append cached JSON rules as lower-priority
+	// handlers, and JSON rewrites to run after the config's own.
+	rf := getCachedRules()
 	var evalScript string
 	if vm.IsJSConfig() {
-		rf := getCachedRules()
-		runtime.Set("_jsonHandlers", rules.ToJSHandlers(rf.Rules))
+		rewrites, err := rules.ToJSRewrites(rf.Rewrites)
+		if err != nil {
+			return nil, fmt.Errorf("failed to prepare JSON rewrites: %v", err)
+		}
+		runtime.Set("_jsonHandlers", rules.ToJSHandlers(rf.Rules, opener, systemContext{}))
 		evalScript = `finickyConfigAPI.openUrl(url, opener, originalUrl, Object.assign({}, finalConfig, {
+			rewrite: (finalConfig.rewrite || []).concat(` + rewrites + `),
 			handlers: (finalConfig.handlers || []).concat(_jsonHandlers)
-		}))`
+		}), _trace)`
 	} else {
-		evalScript = "finickyConfigAPI.openUrl(url, opener, originalUrl, finalConfig)"
+		evalScript = "finickyConfigAPI.openUrl(url, opener, originalUrl, finalConfig, _trace)"
 	}
 
-	openResult, err := runtime.RunString(evalScript)
+	openResult, err := vm.Run(evalScript)
+	if trace != nil {
+		if traceErr := collectSteps(vm, jsTrace, trace, rf); traceErr != nil {
+			slog.Warn("Failed to collect decision trace", "error", traceErr)
+		}
+	}
 	if err != nil {
+		var timeoutErr *config.TimeoutError
+		if errors.As(err, &timeoutErr) {
+			return nil, fmt.Errorf("%v while running %s", timeoutErr, currentStep(vm))
+		}
 		return nil, fmt.Errorf("failed to evaluate URL in config: %v", err)
 	}
 
@@ -126,6 +281,8 @@ func evaluateURL(vm *config.VM, url string, opener *OpenerInfo) (*browser.Browse
 		"profile", browserResult.Browser.Profile,
 		"args", browserResult.Browser.Args,
 		"appType", browserResult.Browser.AppType,
+		"private", browserResult.Browser.Private,
+		"appWindow", browserResult.Browser.AppWindow,
 	)
 
 	var resultErr error
@@ -135,6 +292,36 @@ func evaluateURL(vm *config.VM, url string, opener *OpenerInfo) (*browser.Browse
 	return &browserResult.Browser, resultErr
 }
 
+// jsRedirectChain converts a redirect chain to the objects openUrl expects,
+// leaving out the status of URLs that weren't requested.
+func jsRedirectChain(chain []shorturl.Hop) []interface{} {
+	hops := make([]interface{}, len(chain))
+	for i, hop := range chain {
+		h := map[string]interface{}{"url": hop.URL}
+		if hop.Status != 0 {
+			h["status"] = hop.Status
+		}
+		hops[i] = h
+	}
+	return hops
+}
+
+// currentStep describes the step openUrl was running when it was interrupted.
+func currentStep(vm *config.VM) string {
+	step, err := vm.Run("finickyConfigAPI.getCurrentStep()")
+	if err != nil || goja.IsNull(step) || goja.IsUndefined(step) {
+		return "config"
+	}
+	obj := step.ToObject(vm.Runtime())
+	kind := obj.Get("kind").String()
+	switch kind {
+	case "handler", "rewrite":
+		return fmt.Sprintf("%s %d", kind, obj.Get("index").ToInteger())
+	default:
+		return "defaultBrowser"
+	}
+}
+
 func defaultBrowserConfig(urlStr string, openInBackground bool) *browser.BrowserConfig {
 	bg := openInBackground
 	return &browser.BrowserConfig{
@@ -1,55 +1,20 @@
 package resolver_test
 
 import (
-	"os"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
 	"testing"
 
+	"finicky/browser"
 	"finicky/config"
+	"finicky/internal/testvm"
 	. "finicky/resolver"
 	"finicky/rules"
+	"finicky/shorturl"
 )
 
-// apiContent reads finickyConfigAPI.js relative to this package directory.
-// go test sets the working directory to the package source directory, so
-// "../assets/..." resolves correctly.
-func apiContent(t *testing.T) []byte {
-	t.Helper()
-	b, err := os.ReadFile("../assets/finickyConfigAPI.js")
-	if err != nil {
-		t.Fatalf("failed to load finickyConfigAPI.js: %v\n(run from apps/finicky/src/resolver/)", err)
-	}
-	return b
-}
-
-// jsVM creates a VM from an inline JS config object literal.
-// NewFromScript bypasses esbuild bundling, so we use the legacy var-assignment
-// syntax that the config API accepts directly in goja.
-// The VM is marked as a JS-config VM to exercise the merge path.
-func jsVM(t *testing.T, configObj string) *config.VM {
-	t.Helper()
-	script := "var finickyConfig = " + configObj
-	vm, err := config.NewFromScript(apiContent(t), "finickyConfig", script)
-	if err != nil {
-		t.Fatalf("failed to create VM from JS: %v", err)
-	}
-	vm.SetIsJSConfig(true)
-	return vm
-}
-
-// rulesVM creates a VM from a RulesFile (the no-JS-config path).
-func rulesVM(t *testing.T, rf rules.RulesFile) *config.VM {
-	t.Helper()
-	script, err := rules.ToJSConfigScript(rf, "finickyConfig")
-	if err != nil {
-		t.Fatalf("failed to generate JS config from rules: %v", err)
-	}
-	vm, err := config.NewFromScript(apiContent(t), "finickyConfig", script)
-	if err != nil {
-		t.Fatalf("failed to create VM from rules: %v", err)
-	}
-	return vm
-}
-
 func TestResolveURL_NoConfig(t *testing.T) {
 	result, err := ResolveURL(nil, "https://example.com", nil, false)
 	if err != nil {
@@ -61,7 +26,7 @@ func TestResolveURL_NoConfig(t *testing.T) {
 }
 
 func TestResolveURL_JSConfig(t *testing.T) {
-	vm := jsVM(t, `({
+	vm := testvm.JS(t, `({
 		defaultBrowser: "Safari",
 		handlers: [
 			{ match: "*github.com/*", browser: "Firefox" },
@@ -91,6 +56,15 @@ func TestResolveURL_JSConfig(t *testing.T) {
 	}
 }
 
+// ruleVMs returns the two ways rf is evaluated: matched in Go, as the app
+// does, and with the config API.
+func ruleVMs(t *testing.T, rf rules.RulesFile) map[string]*config.VM {
+	return map[string]*config.VM{
+		"Go": config.NewFromRules(rf),
+		"JS": testvm.Rules(t, rf),
+	}
+}
+
 func TestResolveURL_JSONRulesOnly(t *testing.T) {
 	rf := rules.RulesFile{
 		DefaultBrowser: "Firefox",
@@ -99,7 +73,6 @@ func TestResolveURL_JSONRulesOnly(t *testing.T) {
 			{Match: []string{"https://linear.app/*"}, Browser: "Safari"},
 		},
 	}
-	vm := rulesVM(t, rf)
 
 	tests := []struct {
 		url     string
@@ -109,16 +82,18 @@ func TestResolveURL_JSONRulesOnly(t *testing.T) {
 		{"https://linear.app/team/issue/123", "Safari"},
 		{"https://example.com", "Firefox"},
 	}
-	for _, tt := range tests {
-		t.Run(tt.url, func(t *testing.T) {
-			result, err := ResolveURL(vm, tt.url, nil, false)
-			if err != nil {
-				t.Fatal(err)
-			}
-			if result.Name != tt.browser {
-				t.Errorf("got %q, want %q", result.Name, tt.browser)
-			}
-		})
+	for name, vm := range ruleVMs(t, rf) {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.url, func(t *testing.T) {
+				result, err := ResolveURL(vm, tt.url, nil, false)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if result.Name != tt.browser {
+					t.Errorf("got %q, want %q", result.Name, tt.browser)
+				}
+			})
+		}
 	}
 }
 
@@ -129,17 +104,20 @@ func TestResolveURL_JSONRulesWithProfile(t *testing.T) {
 			{Match: []string{"*github.com/*"}, Browser: "Google Chrome", Profile: "Work"},
 		},
 	}
-	vm := rulesVM(t, rf)
 
-	result, err := ResolveURL(vm, "https://github.com/foo", nil, false)
-	if err != nil {
-		t.Fatal(err)
-	}
-	if result.Name != "Google Chrome" {
-		t.Errorf("browser: got %q, want %q", result.Name, "Google Chrome")
-	}
-	if result.Profile != "Work" {
-		t.Errorf("profile: got %q, want %q", result.Profile, "Work")
+	for name, vm := range ruleVMs(t, rf) {
+		t.Run(name, func(t *testing.T) {
+			result, err := ResolveURL(vm, "https://github.com/foo", nil, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Name != "Google Chrome" {
+				t.Errorf("browser: got %q, want %q", result.Name, "Google Chrome")
+			}
+			if result.Profile != "Work" {
+				t.Errorf("profile: got %q, want %q", result.Profile, "Work")
+			}
+		})
 	}
 }
 
@@ -149,7 +127,7 @@ func TestResolveURL_MergedJSAndJSON(t *testing.T) {
 	// JS config handles github. jsVM sets IsJSConfig=true so the merge path
 	// is exercised. With no rules cached, _jsonHandlers is [], so finalConfig
 	// is used as-is — JS handlers apply normally.
-	jsConfig := jsVM(t, `({
+	jsConfig := testvm.JS(t, `({
 		defaultBrowser: "Safari",
 		handlers: [
 			{ match: "*github.com/*", browser: "Firefox" }
@@ -181,7 +159,7 @@ func TestResolveURL_MergedJSAndJSON(t *testing.T) {
 // (simulating the fix for the startup bug where SetCachedRules was not called
 // when a JS config existed).
 func TestResolveURL_MergedJSAndJSON_WithCachedRules(t *testing.T) {
-	jsConfig := jsVM(t, `({
+	jsConfig := testvm.JS(t, `({
 		defaultBrowser: "Safari",
 		handlers: [
 			{ match: "*github.com/*", browser: "Firefox" }
@@ -200,9 +178,9 @@ func TestResolveURL_MergedJSAndJSON_WithCachedRules(t *testing.T) {
 		url     string
 		browser string
 	}{
-		{"https://github.com/foo", "Firefox"},          // JS handler wins
+		{"https://github.com/foo", "Firefox"},                // JS handler wins
 		{"https://linear.app/team/issue/1", "Google Chrome"}, // JSON rule applies
-		{"https://example.com", "Safari"},              // JS default
+		{"https://example.com", "Safari"},                    // JS default
 	}
 	for _, tt := range tests {
 		t.Run(tt.url, func(t *testing.T) {
@@ -218,7 +196,7 @@ func TestResolveURL_MergedJSAndJSON_WithCachedRules(t *testing.T) {
 }
 
 func TestResolveURL_JSConfigFunctionHandler(t *testing.T) {
-	vm := jsVM(t, `({
+	vm := testvm.JS(t, `({
 		defaultBrowser: "Safari",
 		handlers: [{
 			match: function(request, { opener }) {
@@ -249,7 +227,7 @@ func TestResolveURL_JSConfigFunctionHandler(t *testing.T) {
 }
 
 func TestResolveURL_RewriteRule(t *testing.T) {
-	vm := jsVM(t, `({
+	vm := testvm.JS(t, `({
 		defaultBrowser: "Safari",
 		rewrite: [{
 			match: "https://www.youtube.com/watch*",
@@ -277,3 +255,116 @@ func TestResolveURL_OpenInBackground(t *testing.T) {
 		t.Error("expected OpenInBackground=true")
 	}
 }
+
+func TestResolveURL_EvaluationTimeout(t *testing.T) {
+	// The fallback would pick cached rules or a recorded system default
+	browser.SetSystemDefaultPath(filepath.Join(t.TempDir(), "system_default_browser.json"))
+	t.Cleanup(func() { browser.SetSystemDefaultPath("") })
+	SetCachedRules(rules.RulesFile{})
+	t.Cleanup(func() { SetCachedRules(rules.RulesFile{}) })
+
+	vm := testvm.JS(t, `({
+		defaultBrowser: "Firefox",
+		options: { evaluationTimeoutMs: 50 },
+		handlers: [
+			{ match: "*example.org*", browser: "Google Chrome" },
+			{ match: () => { while (true) {} }, browser: "Google Chrome" }
+		]
+	})`)
+
+	result, err := ResolveURL(vm, "https://example.com", nil, false)
+	if err == nil {
+		t.Fatal("expected timeout error")
+	}
+	if want := "evaluation timed out after 50ms while running handler 1"; err.Error() != want {
+		t.Errorf("error: got %q, want %q", err.Error(), want)
+	}
+	if result.Name != "com.apple.Safari" {
+		t.Errorf("expected fallback to default browser, got %q", result.Name)
+	}
+
+	// The VM stays usable after an interrupted evaluation
+	result, err = ResolveURL(vm, "https://example.org", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Name != "Google Chrome" {
+		t.Errorf("got %q, want %q", result.Name, "Google Chrome")
+	}
+}
+
+func TestResolveURL_UnwrapsRedirectWrappers(t *testing.T) {
+	vm := testvm.JS(t, `({
+		defaultBrowser: "Safari",
+		handlers: [{
+			match: (url, { originalUrl, urlChain }) =>
+				url.host === "example.com" &&
+				originalUrl.host === "nam12.safelinks.protection.outlook.com" &&
+				urlChain.length === 3,
+			browser: "Firefox"
+		}]
+	})`)
+
+	wrapped := "https://nam12.safelinks.protection.outlook.com/?url=https%3A%2F%2Fwww.google.com%2Furl%3Fq%3Dhttps%253A%252F%252Fexample.com%252Fpage"
+	result, trace, err := Explain(vm, wrapped, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Name != "Firefox" {
+		t.Errorf("got %q, want %q", result.Name, "Firefox")
+	}
+	if result.URL != "https://example.com/page" {
+		t.Errorf("URL: got %q", result.URL)
+	}
+	if len(trace.ShortURL.Unwrapped) != 2 || len(trace.ShortURL.Chain) != 3 {
+		t.Errorf("unexpected expansion trace: %+v", trace.ShortURL)
+	}
+	for _, hop := range trace.ShortURL.Chain {
+		if hop.Status != 0 {
+			t.Errorf("expected no status for unwrapped %v", hop)
+		}
+	}
+}
+
+func TestResolveURL_RedirectChain(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/short", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/track", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/track", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/page", http.StatusFound)
+	})
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	vm := testvm.JS(t, `({
+		defaultBrowser: "Safari",
+		options: { urlShorteners: ["127.0.0.1"], shortUrlCacheTtlHours: 0 },
+		handlers: [{
+			match: (url, { redirectChain }) =>
+				redirectChain.length === 3 &&
+				redirectChain[0].status === 301 &&
+				redirectChain[1].url.pathname === "/track" &&
+				redirectChain[1].status === 302 &&
+				redirectChain[2].status === 200,
+			browser: "Firefox"
+		}]
+	})`)
+
+	result, trace, err := Explain(vm, server.URL+"/short", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Name != "Firefox" {
+		t.Errorf("got %q, want %q", result.Name, "Firefox")
+	}
+	want := []shorturl.Hop{
+		{URL: server.URL + "/short", Status: http.StatusMovedPermanently},
+		{URL: server.URL + "/track", Status: http.StatusFound},
+		{URL: server.URL + "/page", Status: http.StatusOK},
+	}
+	if !reflect.DeepEqual(trace.ShortURL.Chain, want) {
+		t.Errorf("chain: got %v, want %v", trace.ShortURL.Chain, want)
+	}
+}
@@ -0,0 +1,145 @@
+package resolver
+
+import (
+	"encoding/json"
+	"time"
+
+	"finicky/browser"
+	"finicky/config"
+	"finicky/rules"
+	"finicky/shorturl"
+
+	"github.com/dop251/goja"
+)
+
+// Sources of a trace step.
+const (
+	SourceConfig = "config"
+	SourceRules  = "rules"
+)
+
+// Trace explains how a URL was routed: how it was expanded, each rewrite and
+// handler that was evaluated, and the step that decided the browser. When
+// evaluation failed, Fallback names the fallback that decided instead.
+type Trace struct {
+	URL        string        `json:"url"`
+	ShortURL   ShortURLTrace `json:"shortUrl"`
+	Steps      []TraceStep   `json:"steps"`
+	Winner     *TraceStep    `json:"winner,omitempty"`
+	Fallback   string        `json:"fallback,omitempty"`
+	DurationMs float64       `json:"durationMs"`
+}
+
+// ShortURLTrace records how the URL was expanded: Unwrapped lists the
+// destinations of redirect wrappers, and Chain every URL from Original to
+// Resolved, with the status of those that were requested. Resolved equals
+// Original, and Chain is empty, when nothing applied.
+type ShortURLTrace struct {
+	Original   string         `json:"original"`
+	Resolved   string         `json:"resolved"`
+	Unwrapped  []string       `json:"unwrapped,omitempty"`
+	Chain      []shorturl.Hop `json:"chain,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	DurationMs float64        `json:"durationMs"`
+}
+
+// TraceStep is a rewrite, handler or default browser that was evaluated.
+// Index is the position of the rewrite or handler in its source: the JS
+// config's list, or the rules in rules.json.
+type TraceStep struct {
+	Kind       string  `json:"kind"`
+	Index      int     `json:"index"`
+	Source     string  `json:"source"`
+	Matched    bool    `json:"matched"`
+	URL        string  `json:"url,omitempty"`
+	DurationMs float64 `json:"durationMs"`
+}
+
+// Explain resolves a URL like ResolveURL and also returns a trace of the
+// decision. The trace is returned even when evaluation fails, covering the
+// steps that ran before the failure.
+func Explain(vm *config.VM, urlStr string, opener *OpenerInfo, openInBackground bool) (*browser.BrowserConfig, *Trace, error) {
+	startTime := time.Now()
+	trace := &Trace{
+		URL:      urlStr,
+		ShortURL: ShortURLTrace{Original: urlStr, Resolved: urlStr},
+		Steps:    []TraceStep{},
+	}
+	cfg, err := resolve(vm, urlStr, opener, openInBackground, trace)
+	trace.DurationMs = sinceMs(startTime)
+	return cfg, trace, err
+}
+
+// newJSTrace creates the trace object openUrl records its steps in.
+func newJSTrace(runtime *goja.Runtime) *goja.Object {
+	startTime := time.Now()
+	trace := runtime.NewObject()
+	trace.Set("now", func() float64 {
+		return sinceMs(startTime)
+	})
+	trace.Set("steps", runtime.NewArray())
+	return trace
+}
+
+// collectSteps reads the steps recorded by openUrl into trace, attributing
+// each one to the JS config or rules.json.
+func collectSteps(vm *config.VM, jsTrace *goja.Object, trace *Trace, rulesFile rules.RulesFile) error {
+	stepsJSON, err := json.Marshal(jsTrace.Get("steps").Export())
+	if err != nil {
+		return err
+	}
+	var steps []TraceStep
+	if err := json.Unmarshal(stepsJSON, &steps); err != nil {
+		return err
+	}
+
+	jsHandlers, jsRewrites := 0, 0
+	if vm.IsJSConfig() {
+		count, err := vm.Run("(finalConfig.handlers || []).length")
+		if err != nil {
+			return err
+		}
+		jsHandlers = int(count.ToInteger())
+		count, err = vm.Run("(finalConfig.rewrite || []).length")
+		if err != nil {
+			return err
+		}
+		jsRewrites = int(count.ToInteger())
+	}
+	ruleIndexes := rules.HandlerRuleIndexes(rulesFile.Rules)
+
+	for i := range steps {
+		step := &steps[i]
+		step.Source = SourceConfig
+		if !vm.IsJSConfig() {
+			step.Source = SourceRules
+		}
+		if step.Kind == "rewrite" && step.Index >= jsRewrites {
+			step.Source = SourceRules
+			step.Index -= jsRewrites
+		}
+		if step.Kind == "handler" && step.Index >= jsHandlers {
+			step.Source = SourceRules
+			if ruleIndex := step.Index - jsHandlers; ruleIndex < len(ruleIndexes) {
+				step.Index = ruleIndexes[ruleIndex]
+			}
+		}
+	}
+	for _, step := range steps {
+		trace.addStep(step)
+	}
+	return nil
+}
+
+// addStep appends step, making it the winner when it decided the browser.
+func (t *Trace) addStep(step TraceStep) {
+	t.Steps = append(t.Steps, step)
+	if step.Matched && step.Kind != "rewrite" {
+		winner := step
+		t.Winner = &winner
+	}
+}
+
+func sinceMs(startTime time.Time) float64 {
+	return float64(time.Since(startTime).Microseconds()) / 1000
+}
@@ -0,0 +1,91 @@
+package resolver_test
+
+import (
+	"testing"
+
+	"finicky/internal/testvm"
+	. "finicky/resolver"
+	"finicky/rules"
+)
+
+func TestExplain_MergedJSAndJSON(t *testing.T) {
+	SetCachedRules(rules.RulesFile{
+		Rules: []rules.Rule{
+			{Match: []string{""}, Browser: "Firefox"},
+			{Match: []string{"*linear.app/*"}, Browser: "Google Chrome"},
+		},
+	})
+	t.Cleanup(func() { SetCachedRules(rules.RulesFile{}) })
+
+	vm := testvm.JS(t, `({
+		defaultBrowser: "Safari",
+		rewrite: [{ match: "*linear.app/*", url: (url) => url.href + "?ref=finicky" }],
+		handlers: [{ match: "*github.com/*", browser: "Firefox" }]
+	})`)
+
+	result, trace, err := Explain(vm, "https://linear.app/team/issue/1", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Name != "Google Chrome" {
+		t.Errorf("got %q, want %q", result.Name, "Google Chrome")
+	}
+
+	if trace.ShortURL.Resolved != "https://linear.app/team/issue/1" {
+		t.Errorf("short URL: got %q", trace.ShortURL.Resolved)
+	}
+
+	want := []TraceStep{
+		{Kind: "rewrite", Index: 0, Source: SourceConfig, Matched: true, URL: "https://linear.app/team/issue/1?ref=finicky"},
+		{Kind: "handler", Index: 0, Source: SourceConfig, Matched: false},
+		// The first rule has no pattern and isn't a handler
+		{Kind: "handler", Index: 1, Source: SourceRules, Matched: true},
+	}
+	if len(trace.Steps) != len(want) {
+		t.Fatalf("expected %d steps, got %+v", len(want), trace.Steps)
+	}
+	for i, step := range trace.Steps {
+		step.DurationMs = 0
+		if step != want[i] {
+			t.Errorf("step %d: got %+v, want %+v", i, step, want[i])
+		}
+	}
+
+	if trace.Winner == nil || trace.Winner.Source != SourceRules || trace.Winner.Index != 1 {
+		t.Errorf("winner: got %+v", trace.Winner)
+	}
+}
+
+func TestExplain_DefaultBrowser(t *testing.T) {
+	vm := testvm.Rules(t, rules.RulesFile{
+		DefaultBrowser: "Firefox",
+		Rules:          []rules.Rule{{Match: []string{"*github.com/*"}, Browser: "Google Chrome"}},
+	})
+
+	_, trace, err := Explain(vm, "https://example.com", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trace.Winner == nil || trace.Winner.Kind != "defaultBrowser" || trace.Winner.Source != SourceRules {
+		t.Errorf("winner: got %+v", trace.Winner)
+	}
+}
+
+func TestExplain_Timeout(t *testing.T) {
+	vm := testvm.JS(t, `({
+		defaultBrowser: "Safari",
+		options: { evaluationTimeoutMs: 50 },
+		handlers: [
+			{ match: "*example.org*", browser: "Firefox" },
+			{ match: () => { while (true) {} }, browser: "Firefox" }
+		]
+	})`)
+
+	_, trace, err := Explain(vm, "https://example.com", nil, false)
+	if err == nil {
+		t.Fatal("expected timeout error")
+	}
+	if len(trace.Steps) != 1 || trace.Winner != nil {
+		t.Errorf("expected the step before the timeout and no winner, got %+v", trace)
+	}
+}
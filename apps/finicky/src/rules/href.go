@@ -0,0 +1,121 @@
+package rules
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// strayPercent stands in for a "%" that doesn't start an escape while a URL
+// is parsed, as url.Parse rejects what WHATWG URL parsers keep as it is. It is
+// an escaped noncharacter, which real URLs don't contain.
+const strayPercent = "%EF%B7%90"
+
+// parseURL parses rawURL like url.Parse, but accepts a "%" that isn't
+// followed by two hex digits, such as in "/sale-50%-off". The URL it returns
+// has strayPercent in its place; restorePercents turns it back.
+func parseURL(rawURL string) (*url.URL, error) {
+	if !strings.Contains(rawURL, "%") {
+		return url.Parse(rawURL)
+	}
+	var b strings.Builder
+	for i := 0; i < len(rawURL); i++ {
+		if rawURL[i] == '%' && (i+2 >= len(rawURL) || !isHex(rawURL[i+1]) || !isHex(rawURL[i+2])) {
+			b.WriteString(strayPercent)
+			continue
+		}
+		b.WriteByte(rawURL[i])
+	}
+	return url.Parse(b.String())
+}
+
+// restorePercents turns the stray percent signs parseURL replaced back.
+func restorePercents(s string) string {
+	return strings.ReplaceAll(s, strayPercent, "%")
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// whatwgIDNA is the UTS #46 processing of the WHATWG URL standard's domain to
+// ASCII: non-transitional, allowing any ASCII and not checking hyphens or
+// lengths.
+var whatwgIDNA = idna.New(
+	idna.MapForLookup(),
+	idna.Transitional(false),
+	idna.BidiRule(),
+	idna.StrictDomainName(false),
+	idna.CheckHyphens(false),
+	idna.VerifyDNSLength(false),
+)
+
+// asciiHost maps host to ASCII as WHATWG URL parsers do: lowercased, with
+// full-width characters and dots mapped and non-ASCII labels in punycode. A
+// host that can't be mapped, which a WHATWG parser would reject, is only
+// lowercased.
+func asciiHost(host string) string {
+	if isASCII(host) {
+		return strings.ToLower(host)
+	}
+	ascii, err := whatwgIDNA.ToASCII(host)
+	if err != nil {
+		return strings.ToLower(host)
+	}
+	return ascii
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// percentEncode encodes controls, spaces, non-ASCII bytes and the bytes in
+// set. Existing escapes are kept as they are.
+func percentEncode(s string, set string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c >= 0x7f || strings.IndexByte(set, c) >= 0 {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// removeDotSegments resolves the "." and ".." segments of a path, including
+// percent-encoded ones, and makes sure it starts with "/".
+func removeDotSegments(path string) string {
+	segments := strings.Split(path, "/")
+	if len(segments) > 0 && segments[0] == "" {
+		segments = segments[1:]
+	}
+	var out []string
+	for i, segment := range segments {
+		last := i == len(segments)-1
+		switch strings.ToLower(segment) {
+		case ".", "%2e":
+			if last {
+				out = append(out, "")
+			}
+		case "..", ".%2e", "%2e.", "%2e%2e":
+			if len(out) > 0 {
+				out = out[:len(out)-1]
+			}
+			if last {
+				out = append(out, "")
+			}
+		default:
+			out = append(out, segment)
+		}
+	}
+	return "/" + strings.Join(out, "/")
+}
@@ -0,0 +1,288 @@
+package rules
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Target is the browser a URL is routed to.
+type Target struct {
+	Browser   string
+	AppType   string
+	Profile   string
+	Args      []string
+	Private   bool
+	AppWindow bool
+	Fallbacks []Target
+}
+
+// Opener describes the app a URL was opened from.
+type Opener struct {
+	Name        string `json:"name"`
+	BundleID    string `json:"bundleId"`
+	Path        string `json:"path"`
+	WindowTitle string `json:"windowTitle,omitempty"`
+}
+
+// defaultBrowser is used when the rules file doesn't set one.
+const defaultBrowser = "com.apple.Safari"
+
+// schemePrefix is prepended to patterns without a scheme, so "example.com/*"
+// matches "https://example.com/".
+const schemePrefix = `(?:https?:|ftp:|mailto:|file:|tel:|sms:|data:)?(?://)?`
+
+var (
+	hasScheme = regexp.MustCompile(`^\w+:`)
+
+	patternCacheMu sync.Mutex
+	patternCache   = map[string]*regexp.Regexp{}
+)
+
+// MatchWildcard reports whether str matches pattern, with the semantics of
+// the config API's matchWildcard: * matches any run of characters, \* matches
+// a literal asterisk, and a pattern without a scheme also matches URLs with
+// one.
+func MatchWildcard(pattern string, str string) bool {
+	if !strings.Contains(pattern, "*") {
+		return pattern == str
+	}
+	re, err := compileWildcard(pattern)
+	if err != nil {
+		return false
+	}
+	return re.MatchString(str)
+}
+
+func compileWildcard(pattern string) (*regexp.Regexp, error) {
+	patternCacheMu.Lock()
+	defer patternCacheMu.Unlock()
+
+	if re, ok := patternCache[pattern]; ok {
+		return re, nil
+	}
+
+	const escapedAsterisk = "\x00"
+	parts := strings.Split(strings.ReplaceAll(pattern, `\*`, escapedAsterisk), "*")
+	for i, part := range parts {
+		parts[i] = strings.ReplaceAll(regexp.QuoteMeta(part), escapedAsterisk, `\*`)
+	}
+	expr := strings.Join(parts, ".*?")
+
+	if !hasScheme.MatchString(pattern) {
+		if !strings.HasPrefix(pattern, "*") {
+			expr = schemePrefix + expr
+		}
+	} else if strings.HasSuffix(pattern, "//") {
+		// The config API appends ".*" before replacing asterisks, so at
+		// least one character must follow
+		expr += "..*?"
+	}
+
+	re, err := regexp.Compile("^" + expr + "$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid wildcard pattern %q: %v", pattern, err)
+	}
+	patternCache[pattern] = re
+	return re, nil
+}
+
+// Matches reports whether any of the rule's patterns matches href and the
+// rule's opener and when conditions hold for opener and ctx. A rule without a
+// browser or patterns never matches.
+func (r Rule) Matches(href string, opener *Opener, ctx Context) bool {
+	if r.Browser == "" || !r.conditionsHold(opener, ctx) {
+		return false
+	}
+	for _, pattern := range r.patterns() {
+		if MatchWildcard(pattern, href) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) conditionsHold(opener *Opener, ctx Context) bool {
+	return r.Opener.Matches(opener) && r.When.Matches(ctx)
+}
+
+// Matches reports whether opener satisfies the condition. A nil or empty
+// condition matches any opener, including none; otherwise a nil opener never
+// matches.
+func (c *OpenerCondition) Matches(opener *Opener) bool {
+	if c == nil || *c == (OpenerCondition{}) {
+		return true
+	}
+	if opener == nil {
+		return false
+	}
+	if c.BundleID != "" && !strings.EqualFold(c.BundleID, opener.BundleID) {
+		return false
+	}
+	if c.Name != "" && !strings.EqualFold(c.Name, opener.Name) {
+		return false
+	}
+	if c.WindowTitle != "" && !matchGlob(c.WindowTitle, opener.WindowTitle, true) {
+		return false
+	}
+	return true
+}
+
+// matchGlob reports whether str matches pattern, where * matches any run of
+// characters. Unlike MatchWildcard, no scheme is implied.
+func matchGlob(pattern string, str string, foldCase bool) bool {
+	if !strings.Contains(pattern, "*") {
+		if foldCase {
+			return strings.EqualFold(pattern, str)
+		}
+		return pattern == str
+	}
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	flags := "(?s)"
+	if foldCase {
+		flags = "(?is)"
+	}
+	re, err := regexp.Compile(flags + "^" + strings.Join(parts, ".*") + "$")
+	if err != nil {
+		return false
+	}
+	return re.MatchString(str)
+}
+
+// Target returns the browser the rule routes to.
+func (r Rule) Target() Target {
+	t := browserTarget(r.Browser, r.Profile)
+	t.Args = r.Args
+	t.Private = r.Private
+	t.AppWindow = r.AppWindow
+	for _, f := range r.fallbacks() {
+		t.Fallbacks = append(t.Fallbacks, browserTarget(f, ""))
+	}
+	return t
+}
+
+// DefaultTarget returns the browser for URLs that no rule matches.
+func (rf RulesFile) DefaultTarget() Target {
+	browser := rf.DefaultBrowser
+	if browser == "" {
+		browser = defaultBrowser
+	}
+	return browserTarget(browser, rf.DefaultProfile)
+}
+
+// Match returns the target for href opened from opener in ctx and the index
+// of the rule that matched, or -1 when the default browser applies.
+func (rf RulesFile) Match(href string, opener *Opener, ctx Context) (Target, int) {
+	for _, i := range HandlerRuleIndexes(rf.Rules) {
+		if rf.Rules[i].Matches(href, opener, ctx) {
+			return rf.Rules[i].Target(), i
+		}
+	}
+	return rf.DefaultTarget(), -1
+}
+
+// browserTarget mirrors how the config API reads a browser: a name with a
+// separate profile is an app name, while a plain "name:profile" string has
+// its app type detected from the name.
+func browserTarget(browser string, profile string) Target {
+	if profile != "" {
+		return Target{Browser: browser, AppType: "appName", Profile: profile}
+	}
+	name, profile, _ := strings.Cut(browser, ":")
+	profile, _, _ = strings.Cut(profile, ":")
+	return Target{Browser: name, AppType: detectAppType(name), Profile: profile}
+}
+
+var (
+	appNamePattern  = regexp.MustCompile(`^[a-zA-Z0-9 ]+$`)
+	bundleIDPattern = regexp.MustCompile(`^[a-zA-Z0-9.-]+$`)
+	appPathPattern  = regexp.MustCompile(`^(~?(?:/[^/\n]+)+/[^/\n]+\.app)$`)
+)
+
+func detectAppType(name string) string {
+	switch {
+	case appNamePattern.MatchString(name):
+		return "appName"
+	case bundleIDPattern.MatchString(name):
+		return "bundleId"
+	case appPathPattern.MatchString(name):
+		return "path"
+	default:
+		return "appName"
+	}
+}
+
+// specialSchemes have an authority and a path that is at least "/".
+var specialSchemes = map[string]string{
+	"http":  "80",
+	"https": "443",
+	"ws":    "80",
+	"wss":   "443",
+	"ftp":   "21",
+	"file":  "",
+}
+
+// NormalizeURL returns rawURL the way the WHATWG URL parser serializes it as
+// href, which is what patterns are matched against: a lowercase scheme and
+// host, IDN hosts in punycode, no default port, a path of at least "/" with
+// dot segments removed, and spaces, quotes and non-ASCII characters
+// percent-encoded. A "%" that doesn't start an escape is kept as it is.
+// Hosts are mapped with UTS #46 as in the standard. IPv6 addresses aren't
+// compressed and backslashes aren't read as slashes, and URLs a WHATWG parser
+// rejects, such as ones with invalid IDN hosts, are normalized as far as
+// possible instead, so such URLs may still serialize differently.
+func NormalizeURL(rawURL string) (string, error) {
+	u, err := parseURL(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %q: %v", rawURL, err)
+	}
+	if u.Scheme == "" {
+		return "", fmt.Errorf("invalid URL %q: missing scheme", rawURL)
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+
+	defaultPort, special := specialSchemes[u.Scheme]
+	if !special || u.Opaque != "" {
+		return restorePercents(u.String()), nil
+	}
+
+	var href strings.Builder
+	href.WriteString(u.Scheme + "://")
+	if u.User != nil {
+		href.WriteString(u.User.String() + "@")
+	}
+	host := asciiHost(u.Hostname())
+	if u.Scheme == "file" && host == "localhost" {
+		host = ""
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	href.WriteString(host)
+	if port := u.Port(); port != "" && port != defaultPort {
+		href.WriteString(":" + port)
+	}
+
+	// The Raw fields hold the URL as written when Go would escape it
+	// differently
+	path := u.RawPath
+	if path == "" {
+		path = u.EscapedPath()
+	}
+	href.WriteString(percentEncode(removeDotSegments(path), "\"<>`{}"))
+	if u.RawQuery != "" || u.ForceQuery {
+		href.WriteString("?" + percentEncode(u.RawQuery, "\"<>'"))
+	}
+	if strings.Contains(rawURL, "#") {
+		fragment := u.RawFragment
+		if fragment == "" {
+			fragment = u.EscapedFragment()
+		}
+		href.WriteString("#" + percentEncode(fragment, "\"<>`"))
+	}
+	return restorePercents(href.String()), nil
+}
@@ -0,0 +1,163 @@
+package rules_test
+
+import (
+	"reflect"
+	"testing"
+
+	. "finicky/rules"
+)
+
+// Cases follow wildcard.test.ts in the config API.
+func TestMatchWildcard(t *testing.T) {
+	cases := []struct {
+		pattern string
+		str     string
+		want    bool
+	}{
+		{"https://example.com/", "https://example.com/", true},
+		{"https://example.com", "https://example.com/", false},
+		{"https://*.example.com/*", "https://www.example.com/path", true},
+		{"https://*.example.com/*", "https://example.com/path", false},
+		{"example.com/*", "https://example.com/path", true},
+		{"example.com/*", "http://example.com/", true},
+		{"example.com/*", "https://notexample.com/", false},
+		{"*github.com/*", "https://github.com/johnste/finicky", true},
+		{"*github.com/*", "https://gist.github.com/x", true},
+		{"http://*", "https://example.com/", false},
+		{"https://", "https://example.com/", false},
+		{"https://*", "https://example.com/", true},
+		{"https://example.com/?q=*", "https://example.com/?q=a+b", true},
+		{"https://example.com/a.b*", "https://example.com/aXb", false},
+		{`https://example.com/\**`, "https://example.com/*star", true},
+		{`https://example.com/\**`, "https://example.com/star", false},
+		{"mailto:*@example.com", "mailto:me@example.com", true},
+		{"*", "anything", true},
+		{"https://*//", "https://example.com//", false},
+		{"https://*//", "https://example.com//x", true},
+		{"file:///*", "file:///Users/me/notes.txt", true},
+	}
+	for _, c := range cases {
+		if got := MatchWildcard(c.pattern, c.str); got != c.want {
+			t.Errorf("MatchWildcard(%q, %q) = %v, want %v", c.pattern, c.str, got, c.want)
+		}
+	}
+}
+
+func TestNormalizeURL(t *testing.T) {
+	cases := []struct {
+		input string
+		want  string
+	}{
+		{"https://example.com", "https://example.com/"},
+		{"HTTPS://Example.COM/Path", "https://example.com/Path"},
+		{"https://example.com:443/a", "https://example.com/a"},
+		{"http://example.com:8080", "http://example.com:8080/"},
+		{"https://example.com?q=1#top", "https://example.com/?q=1#top"},
+		{"mailto:me@example.com", "mailto:me@example.com"},
+		// What new URL(input).href returns
+		{"ftp://example.com:21/", "ftp://example.com/"},
+		{"https://example.com:/x", "https://example.com/x"},
+		{"https://[::1]:443/", "https://[::1]/"},
+		{"https://bücher.de/x", "https://xn--bcher-kva.de/x"},
+		{"https://例え.JP/", "https://xn--r8jz45g.jp/"},
+		{"https://ＥＸＡＭＰＬＥ．com/", "https://example.com/"},
+		{"https://faß.de/", "https://xn--fa-hia.de/"},
+		{"https://xn--bcher-kva.de/", "https://xn--bcher-kva.de/"},
+		{"https://my_host.example.com/", "https://my_host.example.com/"},
+		{"https://example.com/?", "https://example.com/?"},
+		{"https://example.com#", "https://example.com/#"},
+		{"https://example.com/a b/ä", "https://example.com/a%20b/%C3%A4"},
+		{"https://example.com/?q=a b&r='ä'", "https://example.com/?q=a%20b&r=%27%C3%A4%27"},
+		{"https://example.com/%7euser/%2E%2E/A%2fb", "https://example.com/A%2fb"},
+		{"https://example.com/a/./b/../c/..", "https://example.com/a/"},
+		{"https://example.com/{x}#a b{c}", "https://example.com/%7Bx%7D#a%20b{c}"},
+		{"file://localhost/etc/hosts", "file:///etc/hosts"},
+		{"file:///Users/me/notes.txt", "file:///Users/me/notes.txt"},
+		{"https://example.com/sale-50%-off", "https://example.com/sale-50%-off"},
+		{"https://example.com/100%?q=5%&r=%2#x%", "https://example.com/100%?q=5%&r=%2#x%"},
+		{"https://example.com/a b/50%", "https://example.com/a%20b/50%"},
+		{"mailto:50%@example.com", "mailto:50%@example.com"},
+	}
+	for _, c := range cases {
+		got, err := NormalizeURL(c.input)
+		if err != nil {
+			t.Errorf("NormalizeURL(%q): %v", c.input, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("NormalizeURL(%q) = %q, want %q", c.input, got, c.want)
+		}
+	}
+
+	if _, err := NormalizeURL("example.com"); err == nil {
+		t.Error("expected error for URL without scheme")
+	}
+}
+
+func TestRulesFile_Match(t *testing.T) {
+	rf := RulesFile{
+		DefaultBrowser: "Firefox",
+		DefaultProfile: "Personal",
+		Rules: []Rule{
+			{Match: []string{""}, Browser: "Safari"},
+			{Match: []string{"*linear.app/*"}, Browser: "Google Chrome", Profile: "Work"},
+			{Match: []string{"*github.com/*", "*gitlab.com/*"}, Browser: "com.google.Chrome:Dev"},
+		},
+	}
+
+	cases := []struct {
+		url  string
+		want Target
+		rule int
+	}{
+		{"https://linear.app/team", Target{Browser: "Google Chrome", AppType: "appName", Profile: "Work"}, 1},
+		{"https://gitlab.com/foo", Target{Browser: "com.google.Chrome", AppType: "bundleId", Profile: "Dev"}, 2},
+		{"https://example.com/", Target{Browser: "Firefox", AppType: "appName", Profile: "Personal"}, -1},
+	}
+	for _, c := range cases {
+		got, rule := rf.Match(c.url, nil, nil)
+		if !reflect.DeepEqual(got, c.want) || rule != c.rule {
+			t.Errorf("Match(%q) = %+v, %d; want %+v, %d", c.url, got, rule, c.want, c.rule)
+		}
+	}
+
+	if got := (RulesFile{}).DefaultTarget(); got.Browser != "com.apple.Safari" || got.AppType != "bundleId" {
+		t.Errorf("empty default: got %+v", got)
+	}
+	if got := (Rule{Browser: "/Applications/Firefox Nightly.app"}).Target(); got.AppType != "path" {
+		t.Errorf("path app type: got %+v", got)
+	}
+}
+
+func TestRule_MatchesOpener(t *testing.T) {
+	rule := Rule{
+		Match:   []string{"*"},
+		Browser: "Firefox",
+		Opener:  &OpenerCondition{BundleID: "com.tinyspeck.slackmacgap", WindowTitle: "*Engineering*"},
+	}
+	slack := &Opener{Name: "Slack", BundleID: "com.tinyspeck.SlackMacGap", WindowTitle: "#engineering - Acme"}
+
+	cases := []struct {
+		name   string
+		opener *Opener
+		want   bool
+	}{
+		{"matching opener", slack, true},
+		{"no opener", nil, false},
+		{"other app", &Opener{Name: "Mail", BundleID: "com.apple.mail", WindowTitle: "Engineering"}, false},
+		{"other window", &Opener{Name: "Slack", BundleID: "com.tinyspeck.slackmacgap", WindowTitle: "#random"}, false},
+	}
+	for _, c := range cases {
+		if got := rule.Matches("https://example.com/", c.opener, nil); got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	byName := Rule{Match: []string{"*"}, Browser: "Firefox", Opener: &OpenerCondition{Name: "slack"}}
+	if !byName.Matches("https://example.com/", slack, nil) {
+		t.Error("expected app name to match case-insensitively")
+	}
+	if !(Rule{Match: []string{"*"}, Browser: "Firefox"}).Matches("https://example.com/", nil, nil) {
+		t.Error("expected rule without opener condition to match without an opener")
+	}
+}
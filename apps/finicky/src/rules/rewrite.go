@@ -0,0 +1,206 @@
+package rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Rewrite changes URLs that match one of its patterns before they are routed.
+// Its actions run in a fixed order: ForceHTTPS, Host, RemoveQuery, SetQuery
+// and finally Replace, which sees the result of the others.
+type Rewrite struct {
+	Match []string `json:"match"`
+	// ForceHTTPS changes http URLs to https.
+	ForceHTTPS bool `json:"forceHttps,omitempty"`
+	// Host replaces the host, and the port if Host has one.
+	Host string `json:"host,omitempty"`
+	// RemoveQuery lists query parameters to drop. * matches any run of
+	// characters, so "utm_*" drops all UTM parameters.
+	RemoveQuery []string `json:"removeQuery,omitempty"`
+	// SetQuery sets query parameters, replacing existing values.
+	SetQuery map[string]string `json:"setQuery,omitempty"`
+	// Replace runs a regular expression replacement on the full URL.
+	Replace *RegexReplace `json:"replace,omitempty"`
+}
+
+// RegexReplace replaces matches of Pattern, a Go regular expression, with
+// With, where $1 or ${name} refer to submatches.
+type RegexReplace struct {
+	Pattern string `json:"pattern"`
+	With    string `json:"with"`
+}
+
+// UnmarshalJSON accepts both a single string and an array for the match field.
+func (rw *Rewrite) UnmarshalJSON(data []byte) error {
+	type rewriteAlias Rewrite
+	var raw struct {
+		rewriteAlias
+		Match json.RawMessage `json:"match"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*rw = Rewrite(raw.rewriteAlias)
+	rw.Match = nil
+	if raw.Match != nil {
+		var s string
+		if err := json.Unmarshal(raw.Match, &s); err == nil {
+			rw.Match = []string{s}
+			return nil
+		}
+		return json.Unmarshal(raw.Match, &rw.Match)
+	}
+	return nil
+}
+
+// MarshalJSON serializes match as a plain string when there is only one entry.
+func (rw Rewrite) MarshalJSON() ([]byte, error) {
+	type rewriteAlias Rewrite
+	var match interface{}
+	if len(rw.Match) == 1 {
+		match = rw.Match[0]
+	} else {
+		match = rw.Match
+	}
+	return json.Marshal(struct {
+		Match interface{} `json:"match"`
+		rewriteAlias
+	}{match, rewriteAlias(rw)})
+}
+
+// Matches reports whether any of the rewrite's patterns matches href.
+func (rw Rewrite) Matches(href string) bool {
+	for _, m := range rw.Match {
+		if m != "" && MatchWildcard(m, href) {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply returns href with the rewrite's actions applied. It doesn't check
+// whether href matches.
+func (rw Rewrite) Apply(href string) (string, error) {
+	u, err := parseURL(href)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %q: %v", href, err)
+	}
+
+	if rw.ForceHTTPS && strings.EqualFold(u.Scheme, "http") {
+		u.Scheme = "https"
+		if u.Port() == "80" {
+			u.Host = u.Hostname()
+		}
+	}
+	if rw.Host != "" {
+		u.Host = rw.Host
+	}
+	if len(rw.RemoveQuery) > 0 || len(rw.SetQuery) > 0 {
+		u.RawQuery = rewriteQuery(u.RawQuery, rw.RemoveQuery, rw.SetQuery)
+	}
+
+	result := restorePercents(u.String())
+	if rw.Replace != nil {
+		re, err := regexp.Compile(rw.Replace.Pattern)
+		if err != nil {
+			return "", fmt.Errorf("invalid replace pattern %q: %v", rw.Replace.Pattern, err)
+		}
+		result = re.ReplaceAllString(result, rw.Replace.With)
+	}
+	return result, nil
+}
+
+// ApplyRewriteJSON applies a JSON encoded Rewrite to href. Rewrites in
+// generated config scripts call it through the VM.
+func ApplyRewriteJSON(rewriteJSON string, href string) (string, error) {
+	var rw Rewrite
+	if err := json.Unmarshal([]byte(rewriteJSON), &rw); err != nil {
+		return "", fmt.Errorf("invalid rewrite: %v", err)
+	}
+	return rw.Apply(href)
+}
+
+// rewriteQuery removes and sets parameters in a raw query, keeping the order
+// and encoding of the parameters it doesn't touch. Set parameters that
+// weren't present are appended in key order.
+func rewriteQuery(rawQuery string, remove []string, set map[string]string) string {
+	var params []string
+	done := map[string]bool{}
+	if rawQuery != "" {
+		for _, param := range strings.Split(rawQuery, "&") {
+			rawKey, _, _ := strings.Cut(param, "=")
+			key, err := url.QueryUnescape(rawKey)
+			if err != nil {
+				key = rawKey
+			}
+			if queryKeyMatches(remove, key) {
+				continue
+			}
+			if value, ok := set[key]; ok {
+				if done[key] {
+					continue
+				}
+				done[key] = true
+				param = url.QueryEscape(key) + "=" + url.QueryEscape(value)
+			}
+			params = append(params, param)
+		}
+	}
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !done[key] {
+			params = append(params, url.QueryEscape(key)+"="+url.QueryEscape(set[key]))
+		}
+	}
+	return strings.Join(params, "&")
+}
+
+func queryKeyMatches(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if matchGlob(pattern, key, false) {
+			return true
+		}
+	}
+	return false
+}
+
+// ToJSRewrites returns a JavaScript array expression with a config API
+// rewrite for each of rewrites, in order. Each one calls back into
+// ApplyRewriteJSON through the _applyRulesRewrite global. A rewrite without
+// patterns gets an empty match list, so indexes stay aligned with rewrites.
+func ToJSRewrites(rewrites []Rewrite) (string, error) {
+	entries := make([]string, 0, len(rewrites))
+	for _, rw := range rewrites {
+		matches := make([]string, 0, len(rw.Match))
+		for _, m := range rw.Match {
+			if m != "" {
+				matches = append(matches, m)
+			}
+		}
+		matchJSON, err := json.Marshal(matches)
+		if err != nil {
+			return "", err
+		}
+		rewriteJSON, err := json.Marshal(rw)
+		if err != nil {
+			return "", err
+		}
+		// Marshal the encoded rewrite again to get a JS string literal.
+		literal, err := json.Marshal(string(rewriteJSON))
+		if err != nil {
+			return "", err
+		}
+		entries = append(entries, fmt.Sprintf(
+			"{match: %s, url: function(url) { return _applyRulesRewrite(%s, url.href); }}",
+			matchJSON, literal))
+	}
+	return "[" + strings.Join(entries, ", ") + "]", nil
+}
@@ -0,0 +1,122 @@
+package rules_test
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	. "finicky/rules"
+)
+
+func TestRewrite_Apply(t *testing.T) {
+	cases := []struct {
+		name    string
+		rewrite Rewrite
+		input   string
+		want    string
+	}{
+		{
+			"remove tracking params",
+			Rewrite{RemoveQuery: []string{"utm_*", "fbclid"}},
+			"https://example.com/a?utm_source=x&id=1&fbclid=abc&utm_medium=y",
+			"https://example.com/a?id=1",
+		},
+		{
+			"remove all params",
+			Rewrite{RemoveQuery: []string{"*"}},
+			"https://example.com/a?a=1&b=2",
+			"https://example.com/a",
+		},
+		{
+			"set params",
+			Rewrite{SetQuery: map[string]string{"lang": "en", "b": "new value"}},
+			"https://example.com/?a=1&lang=sv&c=%2F",
+			"https://example.com/?a=1&lang=en&c=%2F&b=new+value",
+		},
+		{
+			"replace host",
+			Rewrite{Host: "old.reddit.com"},
+			"https://www.reddit.com/r/golang?sort=new",
+			"https://old.reddit.com/r/golang?sort=new",
+		},
+		{
+			"force https",
+			Rewrite{ForceHTTPS: true},
+			"http://example.com:80/path",
+			"https://example.com/path",
+		},
+		{
+			"force https leaves other schemes",
+			Rewrite{ForceHTTPS: true},
+			"ftp://example.com/file",
+			"ftp://example.com/file",
+		},
+		{
+			"regex replace",
+			Rewrite{Replace: &RegexReplace{Pattern: `^https://www\.youtube\.com/watch\?v=([^&]+).*$`, With: "https://youtu.be/$1"}},
+			"https://www.youtube.com/watch?v=dQw4w9WgXcQ&t=1",
+			"https://youtu.be/dQw4w9WgXcQ",
+		},
+		{
+			"replace runs last",
+			Rewrite{Host: "example.org", Replace: &RegexReplace{Pattern: `example\.org`, With: "example.net"}},
+			"https://example.com/",
+			"https://example.net/",
+		},
+		{
+			"stray percent signs",
+			Rewrite{Host: "example.org", RemoveQuery: []string{"utm_*"}},
+			"https://example.com/sale-50%-off?utm_source=x&q=5%",
+			"https://example.org/sale-50%-off?q=5%",
+		},
+	}
+	for _, c := range cases {
+		got, err := c.rewrite.Apply(c.input)
+		if err != nil {
+			t.Errorf("%s: %v", c.name, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+
+	if _, err := (Rewrite{Replace: &RegexReplace{Pattern: "("}}).Apply("https://example.com/"); err == nil {
+		t.Error("expected error for invalid replace pattern")
+	}
+}
+
+func TestRewrite_JSON(t *testing.T) {
+	var rf RulesFile
+	err := json.Unmarshal([]byte(`{
+		"defaultBrowser": "Safari",
+		"rewrites": [
+			{"match": "*example.com/*", "removeQuery": ["utm_*"]},
+			{"match": ["http://*", "*.test/*"], "forceHttps": true, "host": "example.test"}
+		],
+		"rules": []
+	}`), &rf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Rewrite{
+		{Match: []string{"*example.com/*"}, RemoveQuery: []string{"utm_*"}},
+		{Match: []string{"http://*", "*.test/*"}, ForceHTTPS: true, Host: "example.test"},
+	}
+	if !reflect.DeepEqual(rf.Rewrites, want) {
+		t.Fatalf("got %+v, want %+v", rf.Rewrites, want)
+	}
+
+	data, err := json.Marshal(rf.Rewrites[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"match":"*example.com/*","removeQuery":["utm_*"]}`; string(data) != want {
+		t.Errorf("marshal: got %s, want %s", data, want)
+	}
+
+	href, err := ApplyRewriteJSON(string(data), "https://example.com/?utm_source=x")
+	if err != nil || href != "https://example.com/" {
+		t.Errorf("ApplyRewriteJSON: got %q, %v", href, err)
+	}
+}
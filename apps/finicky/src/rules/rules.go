@@ -7,24 +7,53 @@ import (
 	"path/filepath"
 )
 
+// Rule routes URLs matching one of its patterns to Browser. Fallbacks are
+// browsers tried in order when Browser isn't installed or fails to launch.
 type Rule struct {
-	Match   []string `json:"match"`
-	Browser string   `json:"browser"`
-	Profile string   `json:"profile,omitempty"`
+	Match     []string         `json:"match"`
+	Browser   string           `json:"browser"`
+	Profile   string           `json:"profile,omitempty"`
+	Args      []string         `json:"args,omitempty"`
+	Private   bool             `json:"private,omitempty"`
+	AppWindow bool             `json:"appWindow,omitempty"`
+	Fallbacks []string         `json:"fallbacks,omitempty"`
+	Opener    *OpenerCondition `json:"opener,omitempty"`
+	When      *When            `json:"when,omitempty"`
+}
+
+// OpenerCondition restricts a rule to URLs opened from a matching app. Empty
+// fields aren't checked. BundleID and Name are compared case-insensitively,
+// WindowTitle is a pattern where * matches any run of characters.
+type OpenerCondition struct {
+	BundleID    string `json:"bundleId,omitempty"`
+	Name        string `json:"name,omitempty"`
+	WindowTitle string `json:"windowTitle,omitempty"`
 }
 
 // UnmarshalJSON accepts both a single string and an array for the match field.
 func (r *Rule) UnmarshalJSON(data []byte) error {
 	var raw struct {
-		Match   json.RawMessage `json:"match"`
-		Browser string          `json:"browser"`
-		Profile string          `json:"profile,omitempty"`
+		Match     json.RawMessage  `json:"match"`
+		Browser   string           `json:"browser"`
+		Profile   string           `json:"profile,omitempty"`
+		Args      []string         `json:"args,omitempty"`
+		Private   bool             `json:"private,omitempty"`
+		AppWindow bool             `json:"appWindow,omitempty"`
+		Fallbacks []string         `json:"fallbacks,omitempty"`
+		Opener    *OpenerCondition `json:"opener,omitempty"`
+		When      *When            `json:"when,omitempty"`
 	}
 	if err := json.Unmarshal(data, &raw); err != nil {
 		return err
 	}
 	r.Browser = raw.Browser
 	r.Profile = raw.Profile
+	r.Args = raw.Args
+	r.Private = raw.Private
+	r.AppWindow = raw.AppWindow
+	r.Fallbacks = raw.Fallbacks
+	r.Opener = raw.Opener
+	r.When = raw.When
 	if raw.Match != nil {
 		var s string
 		if err := json.Unmarshal(raw.Match, &s); err == nil {
@@ -39,9 +68,15 @@ func (r *Rule) UnmarshalJSON(data []byte) error {
 // MarshalJSON serializes match as a plain string when there is only one entry.
 func (r Rule) MarshalJSON() ([]byte, error) {
 	type RuleAlias struct {
-		Match   interface{} `json:"match"`
-		Browser string      `json:"browser"`
-		Profile string      `json:"profile,omitempty"`
+		Match     interface{}      `json:"match"`
+		Browser   string           `json:"browser"`
+		Profile   string           `json:"profile,omitempty"`
+		Args      []string         `json:"args,omitempty"`
+		Private   bool             `json:"private,omitempty"`
+		AppWindow bool             `json:"appWindow,omitempty"`
+		Fallbacks []string         `json:"fallbacks,omitempty"`
+		Opener    *OpenerCondition `json:"opener,omitempty"`
+		When      *When            `json:"when,omitempty"`
 	}
 	var match interface{}
 	if len(r.Match) == 1 {
@@ -49,7 +84,17 @@ func (r Rule) MarshalJSON() ([]byte, error) {
 	} else {
 		match = r.Match
 	}
-	return json.Marshal(RuleAlias{Match: match, Browser: r.Browser, Profile: r.Profile})
+	return json.Marshal(RuleAlias{
+		Match:     match,
+		Browser:   r.Browser,
+		Profile:   r.Profile,
+		Args:      r.Args,
+		Private:   r.Private,
+		AppWindow: r.AppWindow,
+		Fallbacks: r.Fallbacks,
+		Opener:    r.Opener,
+		When:      r.When,
+	})
 }
 
 type Options struct {
@@ -60,10 +105,11 @@ type Options struct {
 }
 
 type RulesFile struct {
-	DefaultBrowser string   `json:"defaultBrowser"`
-	DefaultProfile string   `json:"defaultProfile,omitempty"`
-	Options        *Options `json:"options,omitempty"`
-	Rules          []Rule   `json:"rules"`
+	DefaultBrowser string    `json:"defaultBrowser"`
+	DefaultProfile string    `json:"defaultProfile,omitempty"`
+	Options        *Options  `json:"options,omitempty"`
+	Rewrites       []Rewrite `json:"rewrites,omitempty"`
+	Rules          []Rule    `json:"rules"`
 }
 
 var customPath string
@@ -141,32 +187,56 @@ func SaveToPath(rf RulesFile, path string) error {
 }
 
 // ToJSHandlers converts rules to the handler format expected by finickyConfigAPI.
-// Rules with an empty match or browser are skipped.
-func ToJSHandlers(rules []Rule) []map[string]interface{} {
+// Rules with an empty match or browser are skipped. Opener and when conditions
+// are checked against opener and ctx here, and a rule whose conditions don't
+// hold gets an empty match list so it keeps its position but never matches.
+func ToJSHandlers(rules []Rule, opener *Opener, ctx Context) []map[string]interface{} {
 	handlers := make([]map[string]interface{}, 0, len(rules))
 	for _, r := range rules {
-		// Filter out empty patterns
-		matches := make([]string, 0, len(r.Match))
-		for _, m := range r.Match {
-			if m != "" {
-				matches = append(matches, m)
-			}
-		}
+		matches := r.patterns()
 		if len(matches) == 0 || r.Browser == "" {
 			continue
 		}
 		var matchVal interface{}
-		if len(matches) == 1 {
+		if !r.conditionsHold(opener, ctx) {
+			matchVal = []string{}
+		} else if len(matches) == 1 {
 			matchVal = matches[0]
 		} else {
 			matchVal = matches
 		}
 		var browser interface{}
-		if r.Profile != "" {
+		switch {
+		case len(r.Args) > 0 || r.Private || r.AppWindow:
+			// Spell out what the config API would parse from the browser
+			// string, as an object doesn't get its app type detected.
+			t := r.Target()
+			b := map[string]interface{}{"name": t.Browser, "appType": t.AppType}
+			if t.Profile != "" {
+				b["profile"] = t.Profile
+			}
+			if len(t.Args) > 0 {
+				b["args"] = t.Args
+			}
+			if t.Private {
+				b["private"] = true
+			}
+			if t.AppWindow {
+				b["appWindow"] = true
+			}
+			browser = b
+		case r.Profile != "":
 			browser = map[string]interface{}{"name": r.Browser, "profile": r.Profile}
-		} else {
+		default:
 			browser = r.Browser
 		}
+		if fallbacks := r.fallbacks(); len(fallbacks) > 0 {
+			list := []interface{}{browser}
+			for _, f := range fallbacks {
+				list = append(list, f)
+			}
+			browser = list
+		}
 		handlers = append(handlers, map[string]interface{}{
 			"match":   matchVal,
 			"browser": browser,
@@ -175,8 +245,44 @@ func ToJSHandlers(rules []Rule) []map[string]interface{} {
 	return handlers
 }
 
+// HandlerRuleIndexes returns, for each handler ToJSHandlers creates from
+// rules, the index of the rule it was created from.
+func HandlerRuleIndexes(rules []Rule) []int {
+	indexes := make([]int, 0, len(rules))
+	for i, r := range rules {
+		if len(r.patterns()) > 0 && r.Browser != "" {
+			indexes = append(indexes, i)
+		}
+	}
+	return indexes
+}
+
+// fallbacks returns the rule's non-empty fallback browsers.
+func (r Rule) fallbacks() []string {
+	fallbacks := make([]string, 0, len(r.Fallbacks))
+	for _, f := range r.Fallbacks {
+		if f != "" {
+			fallbacks = append(fallbacks, f)
+		}
+	}
+	return fallbacks
+}
+
+// patterns returns the rule's non-empty match patterns.
+func (r Rule) patterns() []string {
+	matches := make([]string, 0, len(r.Match))
+	for _, m := range r.Match {
+		if m != "" {
+			matches = append(matches, m)
+		}
+	}
+	return matches
+}
+
 // ToJSConfigScript generates a JavaScript config assignment for the given namespace.
 // It produces a valid finickyConfig object that can be evaluated in the JS VM.
+// The script has no opener or context to check, so rules with opener or when
+// conditions never match in it.
 func ToJSConfigScript(rf RulesFile, namespace string) (string, error) {
 	defaultBrowser := rf.DefaultBrowser
 	if defaultBrowser == "" {
@@ -195,14 +301,23 @@ func ToJSConfigScript(rf RulesFile, namespace string) (string, error) {
 		return "", fmt.Errorf("failed to marshal defaultBrowser: %v", err)
 	}
 
-	handlersJSON, err := json.Marshal(ToJSHandlers(rf.Rules))
+	handlersJSON, err := json.Marshal(ToJSHandlers(rf.Rules, nil, nil))
 	if err != nil {
 		return "", fmt.Errorf("failed to marshal handlers: %v", err)
 	}
 
+	rewrites := ""
+	if len(rf.Rewrites) > 0 {
+		rewritesJS, err := ToJSRewrites(rf.Rewrites)
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal rewrites: %v", err)
+		}
+		rewrites = ", rewrite: " + rewritesJS
+	}
+
 	if rf.Options == nil {
-		return fmt.Sprintf("var %s = {default: {defaultBrowser: %s, handlers: %s}};",
-			namespace, string(defaultBrowserJSON), string(handlersJSON)), nil
+		return fmt.Sprintf("var %s = {default: {defaultBrowser: %s, handlers: %s%s}};",
+			namespace, string(defaultBrowserJSON), string(handlersJSON), rewrites), nil
 	}
 
 	opts := make(map[string]interface{})
@@ -224,6 +339,6 @@ func ToJSConfigScript(rf RulesFile, namespace string) (string, error) {
 		return "", fmt.Errorf("failed to marshal options: %v", err)
 	}
 
-	return fmt.Sprintf("var %s = {default: {defaultBrowser: %s, handlers: %s, options: %s}};",
-		namespace, string(defaultBrowserJSON), string(handlersJSON), string(optsJSON)), nil
+	return fmt.Sprintf("var %s = {default: {defaultBrowser: %s, handlers: %s%s, options: %s}};",
+		namespace, string(defaultBrowserJSON), string(handlersJSON), rewrites, string(optsJSON)), nil
 }
@@ -1,6 +1,7 @@
 package rules_test
 
 import (
+	"encoding/json"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -13,7 +14,7 @@ import (
 // ---- ToJSHandlers ----
 
 func TestToJSHandlers_Empty(t *testing.T) {
-	result := ToJSHandlers([]Rule{})
+	result := ToJSHandlers([]Rule{}, nil, nil)
 	if len(result) != 0 {
 		t.Errorf("expected empty slice, got %d entries", len(result))
 	}
@@ -25,7 +26,7 @@ func TestToJSHandlers_SkipsIncompleteRules(t *testing.T) {
 		{Match: []string{"example.com"}, Browser: ""},     // no browser
 		{Match: []string{"example.com"}, Browser: "Safari"}, // valid
 	}
-	result := ToJSHandlers(rules)
+	result := ToJSHandlers(rules, nil, nil)
 	if len(result) != 1 {
 		t.Fatalf("expected 1 handler, got %d", len(result))
 	}
@@ -35,7 +36,7 @@ func TestToJSHandlers_StringBrowser(t *testing.T) {
 	rules := []Rule{
 		{Match: []string{"*github.com/*"}, Browser: "Firefox"},
 	}
-	result := ToJSHandlers(rules)
+	result := ToJSHandlers(rules, nil, nil)
 	if len(result) != 1 {
 		t.Fatalf("expected 1 handler, got %d", len(result))
 	}
@@ -52,7 +53,7 @@ func TestToJSHandlers_WithProfile(t *testing.T) {
 	rules := []Rule{
 		{Match: []string{"*github.com/*"}, Browser: "Google Chrome", Profile: "Work"},
 	}
-	result := ToJSHandlers(rules)
+	result := ToJSHandlers(rules, nil, nil)
 	if len(result) != 1 {
 		t.Fatalf("expected 1 handler, got %d", len(result))
 	}
@@ -68,13 +69,42 @@ func TestToJSHandlers_WithProfile(t *testing.T) {
 	}
 }
 
+func TestToJSHandlers_BrowserOptions(t *testing.T) {
+	rules := []Rule{
+		{Match: []string{"*github.com/*"}, Browser: "com.google.Chrome:Work", Private: true},
+		{Match: []string{"*linear.app/*"}, Browser: "Google Chrome", AppWindow: true},
+		{Match: []string{"*github.com/*/blob/*"}, Browser: "Visual Studio Code", Args: []string{"{url}"}},
+		{Match: []string{"*"}, Browser: "Arc", Fallbacks: []string{"", "Google Chrome:Work"}},
+	}
+	result := ToJSHandlers(rules, nil, nil)
+	want := []interface{}{
+		map[string]interface{}{"name": "com.google.Chrome", "appType": "bundleId", "profile": "Work", "private": true},
+		map[string]interface{}{"name": "Google Chrome", "appType": "appName", "appWindow": true},
+		map[string]interface{}{"name": "Visual Studio Code", "appType": "appName", "args": []string{"{url}"}},
+		[]interface{}{"Arc", "Google Chrome:Work"},
+	}
+	for i, h := range result {
+		if !reflect.DeepEqual(h["browser"], want[i]) {
+			t.Errorf("handler %d: got %v, want %v", i, h["browser"], want[i])
+		}
+	}
+
+	data, err := json.Marshal(rules[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"match":"*github.com/*","browser":"com.google.Chrome:Work","private":true}`; string(data) != want {
+		t.Errorf("marshal: got %s, want %s", data, want)
+	}
+}
+
 func TestToJSHandlers_MultipleRules(t *testing.T) {
 	rules := []Rule{
 		{Match: []string{"*github.com/*"}, Browser: "Firefox"},
 		{Match: []string{"https://linear.app/*"}, Browser: "Google Chrome", Profile: "Work"},
 		{Match: []string{"example.com"}, Browser: "Safari"},
 	}
-	result := ToJSHandlers(rules)
+	result := ToJSHandlers(rules, nil, nil)
 	if len(result) != 3 {
 		t.Fatalf("expected 3 handlers, got %d", len(result))
 	}
@@ -84,6 +114,26 @@ func TestToJSHandlers_MultipleRules(t *testing.T) {
 	}
 }
 
+func TestToJSHandlers_OpenerCondition(t *testing.T) {
+	rules := []Rule{
+		{Match: []string{"*"}, Browser: "Firefox", Opener: &OpenerCondition{Name: "Slack"}},
+		{Match: []string{"*"}, Browser: "Safari"},
+	}
+
+	result := ToJSHandlers(rules, &Opener{Name: "Mail"}, nil)
+	if len(result) != 2 {
+		t.Fatalf("expected 2 handlers, got %d", len(result))
+	}
+	if m, ok := result[0]["match"].([]string); !ok || len(m) != 0 {
+		t.Errorf("expected empty match for unmet opener condition, got %v", result[0]["match"])
+	}
+
+	result = ToJSHandlers(rules, &Opener{Name: "Slack"}, nil)
+	if result[0]["match"] != "*" {
+		t.Errorf("expected match for met opener condition, got %v", result[0]["match"])
+	}
+}
+
 // ---- ToJSConfigScript ----
 
 func TestToJSConfigScript_DefaultBrowserFallback(t *testing.T) {
@@ -136,6 +186,7 @@ func TestLoadSave_RoundTrip(t *testing.T) {
 		Rules: []Rule{
 			{Match: []string{"*github.com/*"}, Browser: "Google Chrome", Profile: "Personal"},
 			{Match: []string{"https://linear.app/*"}, Browser: "Safari"},
+			{Match: []string{"*"}, Browser: "Firefox", Opener: &OpenerCondition{BundleID: "com.apple.Terminal", WindowTitle: "*ssh*"}},
 		},
 	}
 
@@ -159,7 +210,7 @@ func TestLoadSave_RoundTrip(t *testing.T) {
 	}
 	for i, r := range original.Rules {
 		got := loaded.Rules[i]
-		if !reflect.DeepEqual(got.Match, r.Match) || got.Browser != r.Browser || got.Profile != r.Profile {
+		if !reflect.DeepEqual(got.Match, r.Match) || got.Browser != r.Browser || got.Profile != r.Profile || !reflect.DeepEqual(got.Opener, r.Opener) {
 			t.Errorf("Rule[%d]: got %+v, want %+v", i, got, r)
 		}
 	}
@@ -0,0 +1,172 @@
+package rules
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Context provides the system state that When conditions are checked against.
+type Context interface {
+	// ModifierKeys returns which modifier keys are held, keyed like
+	// finicky.getModifierKeys: shift, option, command, control, capsLock, fn.
+	ModifierKeys() map[string]bool
+	// IsAppRunning reports whether an app with the given bundle ID or name is
+	// running.
+	IsAppRunning(identifier string) bool
+	// Now returns the current local time.
+	Now() time.Time
+}
+
+// When restricts a rule to a context. Every field that is set must hold.
+//
+//   - Modifiers lists modifier keys that must all be held, e.g. ["option"].
+//   - Days lists weekdays or weekday ranges, e.g. ["mon-fri"] or ["sat", "sun"];
+//     any of them may match. Ranges may wrap around the week, e.g. "fri-mon".
+//   - Hours is a time range in local time, "9-17" or "09:30-17:00". The end is
+//     exclusive, and a range may wrap past midnight, e.g. "22-6".
+//   - AppRunning lists apps, by bundle ID or name, that must all be running.
+//
+// A day or hour range that can't be parsed never matches.
+type When struct {
+	Modifiers  []string `json:"modifiers,omitempty"`
+	Days       []string `json:"days,omitempty"`
+	Hours      string   `json:"hours,omitempty"`
+	AppRunning []string `json:"appRunning,omitempty"`
+}
+
+// Matches reports whether the conditions hold in ctx. A nil When always
+// matches; otherwise a nil ctx never matches. ctx is only queried for the
+// conditions that are set.
+func (w *When) Matches(ctx Context) bool {
+	if w == nil || w.isEmpty() {
+		return true
+	}
+	if ctx == nil {
+		return false
+	}
+
+	if len(w.Modifiers) > 0 {
+		held := ctx.ModifierKeys()
+		for _, key := range w.Modifiers {
+			if !modifierHeld(held, key) {
+				return false
+			}
+		}
+	}
+
+	if len(w.Days) > 0 || w.Hours != "" {
+		now := ctx.Now()
+		if len(w.Days) > 0 && !matchDays(w.Days, now.Weekday()) {
+			return false
+		}
+		if w.Hours != "" && !matchHours(w.Hours, now) {
+			return false
+		}
+	}
+
+	for _, app := range w.AppRunning {
+		if !ctx.IsAppRunning(app) {
+			return false
+		}
+	}
+	return true
+}
+
+func (w *When) isEmpty() bool {
+	return len(w.Modifiers) == 0 && len(w.Days) == 0 && w.Hours == "" && len(w.AppRunning) == 0
+}
+
+func modifierHeld(held map[string]bool, key string) bool {
+	for name, down := range held {
+		if strings.EqualFold(name, key) {
+			return down
+		}
+	}
+	return false
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// parseWeekday accepts English weekday names, full or abbreviated to three
+// letters.
+func parseWeekday(s string) (time.Weekday, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if len(s) < 3 {
+		return 0, false
+	}
+	day, ok := weekdays[s[:3]]
+	if !ok || !strings.HasPrefix(strings.ToLower(day.String()), s) {
+		return 0, false
+	}
+	return day, true
+}
+
+func matchDays(days []string, today time.Weekday) bool {
+	for _, spec := range days {
+		from, to, isRange := strings.Cut(spec, "-")
+		start, ok := parseWeekday(from)
+		if !ok {
+			continue
+		}
+		end := start
+		if isRange {
+			if end, ok = parseWeekday(to); !ok {
+				continue
+			}
+		}
+		// Days from start to today and to end, going forward through the week.
+		if (today-start+7)%7 <= (end-start+7)%7 {
+			return true
+		}
+	}
+	return false
+}
+
+// parseClock parses "H", "HH" or "HH:MM" into minutes since midnight. "24"
+// is accepted as the end of the day.
+func parseClock(s string) (int, bool) {
+	hourStr, minStr, hasMinutes := strings.Cut(strings.TrimSpace(s), ":")
+	hour, err := strconv.Atoi(hourStr)
+	if err != nil || hour < 0 || hour > 24 {
+		return 0, false
+	}
+	minute := 0
+	if hasMinutes {
+		if minute, err = strconv.Atoi(minStr); err != nil || minute < 0 || minute > 59 {
+			return 0, false
+		}
+	}
+	if hour == 24 && minute != 0 {
+		return 0, false
+	}
+	return hour*60 + minute, true
+}
+
+func matchHours(spec string, now time.Time) bool {
+	from, to, ok := strings.Cut(spec, "-")
+	if !ok {
+		return false
+	}
+	start, ok := parseClock(from)
+	if !ok {
+		return false
+	}
+	end, ok := parseClock(to)
+	if !ok {
+		return false
+	}
+	minute := now.Hour()*60 + now.Minute()
+	if start <= end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end
+}
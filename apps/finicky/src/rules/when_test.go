@@ -0,0 +1,120 @@
+package rules_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "finicky/rules"
+)
+
+type fakeContext struct {
+	modifiers map[string]bool
+	running   []string
+	now       time.Time
+}
+
+func (c fakeContext) ModifierKeys() map[string]bool { return c.modifiers }
+
+func (c fakeContext) IsAppRunning(identifier string) bool {
+	for _, app := range c.running {
+		if app == identifier {
+			return true
+		}
+	}
+	return false
+}
+
+func (c fakeContext) Now() time.Time { return c.now }
+
+// 2024-06-05 was a Wednesday.
+func at(weekday time.Weekday, hour, minute int) time.Time {
+	return time.Date(2024, 6, 2+int(weekday), hour, minute, 0, 0, time.Local)
+}
+
+func TestWhen_Matches(t *testing.T) {
+	cases := []struct {
+		name string
+		when When
+		ctx  fakeContext
+		want bool
+	}{
+		{"modifier held", When{Modifiers: []string{"option"}}, fakeContext{modifiers: map[string]bool{"option": true}}, true},
+		{"modifier not held", When{Modifiers: []string{"option"}}, fakeContext{modifiers: map[string]bool{"option": false}}, false},
+		{"modifier case", When{Modifiers: []string{"Shift", "command"}}, fakeContext{modifiers: map[string]bool{"shift": true, "command": true}}, true},
+		{"one of two modifiers", When{Modifiers: []string{"shift", "command"}}, fakeContext{modifiers: map[string]bool{"shift": true}}, false},
+
+		{"weekday range", When{Days: []string{"mon-fri"}}, fakeContext{now: at(time.Wednesday, 12, 0)}, true},
+		{"outside weekday range", When{Days: []string{"mon-fri"}}, fakeContext{now: at(time.Saturday, 12, 0)}, false},
+		{"wrapping weekday range", When{Days: []string{"fri-mon"}}, fakeContext{now: at(time.Sunday, 12, 0)}, true},
+		{"whole week", When{Days: []string{"monday-sunday"}}, fakeContext{now: at(time.Sunday, 12, 0)}, true},
+		{"day list", When{Days: []string{"sat", "Sun"}}, fakeContext{now: at(time.Sunday, 12, 0)}, true},
+		{"invalid day", When{Days: []string{"someday"}}, fakeContext{now: at(time.Sunday, 12, 0)}, false},
+
+		{"work hours", When{Hours: "9-17"}, fakeContext{now: at(time.Monday, 9, 0)}, true},
+		{"end is exclusive", When{Hours: "9-17"}, fakeContext{now: at(time.Monday, 17, 0)}, false},
+		{"minutes", When{Hours: "09:30-17:00"}, fakeContext{now: at(time.Monday, 9, 15)}, false},
+		{"overnight", When{Hours: "22-6"}, fakeContext{now: at(time.Monday, 2, 0)}, true},
+		{"overnight, daytime", When{Hours: "22-6"}, fakeContext{now: at(time.Monday, 12, 0)}, false},
+		{"whole day", When{Hours: "0-24"}, fakeContext{now: at(time.Monday, 23, 59)}, true},
+		{"invalid hours", When{Hours: "9"}, fakeContext{now: at(time.Monday, 9, 0)}, false},
+
+		{"work hours on weekdays", When{Days: []string{"mon-fri"}, Hours: "9-17"}, fakeContext{now: at(time.Saturday, 10, 0)}, false},
+
+		{"app running", When{AppRunning: []string{"us.zoom.xos"}}, fakeContext{running: []string{"us.zoom.xos"}}, true},
+		{"app not running", When{AppRunning: []string{"us.zoom.xos"}}, fakeContext{running: []string{"Slack"}}, false},
+
+		{"empty", When{}, fakeContext{}, true},
+	}
+	for _, c := range cases {
+		if got := c.when.Matches(c.ctx); got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	if (&When{Hours: "9-17"}).Matches(nil) {
+		t.Error("expected conditions to fail without a context")
+	}
+	if !(*When)(nil).Matches(nil) {
+		t.Error("expected nil When to match")
+	}
+}
+
+func TestRulesFile_MatchWhen(t *testing.T) {
+	var rf RulesFile
+	err := json.Unmarshal([]byte(`{
+		"defaultBrowser": "Safari",
+		"rules": [
+			{"match": "*", "browser": "Firefox", "when": {"modifiers": ["option"]}},
+			{"match": "*", "browser": "Google Chrome", "profile": "Work", "when": {"days": ["mon-fri"], "hours": "9-17"}}
+		]
+	}`), &rf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		ctx  Context
+		want string
+		rule int
+	}{
+		{fakeContext{modifiers: map[string]bool{"option": true}, now: at(time.Monday, 10, 0)}, "Firefox", 0},
+		{fakeContext{now: at(time.Monday, 10, 0)}, "Google Chrome", 1},
+		{fakeContext{now: at(time.Monday, 20, 0)}, "Safari", -1},
+		{nil, "Safari", -1},
+	}
+	for _, c := range cases {
+		got, rule := rf.Match("https://example.com/", nil, c.ctx)
+		if got.Browser != c.want || rule != c.rule {
+			t.Errorf("ctx %+v: got %q (rule %d), want %q (rule %d)", c.ctx, got.Browser, rule, c.want, c.rule)
+		}
+	}
+
+	data, err := json.Marshal(rf.Rules[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"match":"*","browser":"Google Chrome","profile":"Work","when":{"days":["mon-fri"],"hours":"9-17"}}`; string(data) != want {
+		t.Errorf("marshal: got %s, want %s", data, want)
+	}
+}
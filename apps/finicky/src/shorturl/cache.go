@@ -0,0 +1,273 @@
+package shorturl
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"finicky/util"
+)
+
+// DefaultCacheTTL is how long an expansion is reused when the config doesn't
+// set shortUrlCacheTtlHours.
+const DefaultCacheTTL = 7 * 24 * time.Hour
+
+const (
+	defaultMaxEntries  = 1000
+	defaultNegativeTTL = 10 * time.Minute
+)
+
+// CacheEntry is a cached short URL expansion and its redirect chain. Error is
+// set when the expansion failed, in which case URL is the URL that was used
+// instead.
+type CacheEntry struct {
+	URL        string    `json:"url"`
+	Chain      []Hop     `json:"chain,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	ResolvedAt time.Time `json:"resolvedAt"`
+}
+
+// CachedURL is a cache entry together with the short URL it belongs to.
+type CachedURL struct {
+	ShortURL string `json:"shortUrl"`
+	CacheEntry
+}
+
+// Cache stores short URL expansions on disk, so a link that was expanded
+// before routes without a network request. Failed expansions are cached too,
+// for NegativeTTL, so an unreachable shortener doesn't slow down every open.
+type Cache struct {
+	// MaxEntries caps the number of entries; the oldest are dropped first.
+	MaxEntries int
+	// NegativeTTL is how long a failed expansion is reused.
+	NegativeTTL time.Duration
+
+	path    string
+	mu      sync.Mutex
+	loaded  bool
+	entries map[string]CacheEntry
+}
+
+// NewCache returns a cache stored at path. The file is read on first use.
+func NewCache(path string) *Cache {
+	return &Cache{
+		MaxEntries:  defaultMaxEntries,
+		NegativeTTL: defaultNegativeTTL,
+		path:        path,
+	}
+}
+
+var (
+	cachePath    string
+	defaultCache *Cache
+	cacheMu      sync.Mutex
+)
+
+// SetCachePath overrides where the default cache is stored. Pass an empty
+// string to revert to short_urls.json in the Finicky cache directory.
+// Intended for testing.
+func SetCachePath(path string) {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	cachePath = path
+	defaultCache = nil
+}
+
+// DefaultCache returns the cache shared by the app and the command line.
+func DefaultCache() *Cache {
+	cacheMu.Lock()
+	defer cacheMu.Unlock()
+	if defaultCache == nil {
+		path := cachePath
+		if path == "" {
+			path = defaultCachePath()
+		}
+		defaultCache = NewCache(path)
+	}
+	return defaultCache
+}
+
+func defaultCachePath() string {
+	cacheDir, err := util.UserCacheDir()
+	if err != nil {
+		slog.Error("Error getting user cache directory", "error", err)
+		return ""
+	}
+	return filepath.Join(cacheDir, "Finicky", "short_urls.json")
+}
+
+// Path returns the file the cache is stored in.
+func (c *Cache) Path() string {
+	return c.path
+}
+
+// Get returns the entry for shortURL if it was stored less than ttl ago, or
+// NegativeTTL ago for a failed expansion.
+func (c *Cache) Get(shortURL string, ttl time.Duration) (CacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+
+	entry, ok := c.entries[shortURL]
+	if !ok || !c.fresh(entry, ttl, time.Now()) {
+		return CacheEntry{}, false
+	}
+	return entry, true
+}
+
+// Put stores entry for shortURL and saves the cache.
+func (c *Cache) Put(shortURL string, entry CacheEntry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+
+	if entry.ResolvedAt.IsZero() {
+		entry.ResolvedAt = time.Now()
+	}
+	c.entries[shortURL] = entry
+	c.evict()
+	return c.save()
+}
+
+// List returns all entries, most recent first.
+func (c *Cache) List() []CachedURL {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+
+	list := make([]CachedURL, 0, len(c.entries))
+	for shortURL, entry := range c.entries {
+		list = append(list, CachedURL{ShortURL: shortURL, CacheEntry: entry})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ResolvedAt.After(list[j].ResolvedAt)
+	})
+	return list
+}
+
+// Clear removes all entries and the cache file. It returns how many entries
+// were removed.
+func (c *Cache) Clear() (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.load()
+
+	n := len(c.entries)
+	c.entries = map[string]CacheEntry{}
+	if c.path == "" {
+		return n, nil
+	}
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		return n, err
+	}
+	return n, nil
+}
+
+func (c *Cache) fresh(entry CacheEntry, ttl time.Duration, now time.Time) bool {
+	if entry.Error != "" {
+		ttl = min(ttl, c.NegativeTTL)
+	}
+	return now.Sub(entry.ResolvedAt) < ttl
+}
+
+// evict drops the oldest entries until the cache is within MaxEntries.
+func (c *Cache) evict() {
+	if c.MaxEntries <= 0 || len(c.entries) <= c.MaxEntries {
+		return
+	}
+	keys := make([]string, 0, len(c.entries))
+	for k := range c.entries {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return c.entries[keys[i]].ResolvedAt.Before(c.entries[keys[j]].ResolvedAt)
+	})
+	for _, k := range keys[:len(keys)-c.MaxEntries] {
+		delete(c.entries, k)
+	}
+}
+
+func (c *Cache) load() {
+	if c.loaded {
+		return
+	}
+	c.loaded = true
+	c.entries = map[string]CacheEntry{}
+	if c.path == "" {
+		return
+	}
+
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Debug("Failed to read short URL cache", "error", err)
+		}
+		return
+	}
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		slog.Debug("Failed to parse short URL cache", "error", err)
+		c.entries = map[string]CacheEntry{}
+	}
+}
+
+// save writes the cache to a temporary file and renames it into place, so a
+// concurrent reader never sees a partial file.
+func (c *Cache) save() error {
+	if c.path == "" {
+		return nil
+	}
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return fmt.Errorf("failed to marshal short URL cache: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return fmt.Errorf("failed to create cache directory: %v", err)
+	}
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write short URL cache: %v", err)
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// ExpandCached is like ExpandWithDomains, but reuses an expansion of the same
+// URL from cache when it is younger than ttl, and stores new expansions in
+// it. A nil cache or a ttl of zero disables caching.
+func ExpandCached(originalURL string, domains []string, cache *Cache, ttl time.Duration) (string, []Hop, error) {
+	return defaultResolver.ExpandCached(originalURL, domains, cache, ttl)
+}
+
+// ExpandCached is like the package's ExpandCached, but uses the resolver's
+// client and policy.
+func (r *Resolver) ExpandCached(originalURL string, domains []string, cache *Cache, ttl time.Duration) (string, []Hop, error) {
+	if cache == nil || ttl <= 0 {
+		return r.Expand(originalURL, domains)
+	}
+	if u, err := url.Parse(originalURL); err != nil || !r.shouldExpand(u, domains) {
+		return r.Expand(originalURL, domains)
+	}
+
+	if entry, ok := cache.Get(originalURL, ttl); ok {
+		slog.Debug("Using cached short URL expansion", "url", originalURL, "resolved", entry.URL)
+		if entry.Error != "" {
+			return entry.URL, entry.Chain, fmt.Errorf("%s (cached)", entry.Error)
+		}
+		return entry.URL, entry.Chain, nil
+	}
+
+	resolvedURL, chain, err := r.Expand(originalURL, domains)
+	entry := CacheEntry{URL: resolvedURL, Chain: chain}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	if cacheErr := cache.Put(originalURL, entry); cacheErr != nil {
+		slog.Warn("Failed to save short URL cache", "error", cacheErr)
+	}
+	return resolvedURL, chain, err
+}
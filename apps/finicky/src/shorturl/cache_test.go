@@ -0,0 +1,119 @@
+package shorturl_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "finicky/shorturl"
+)
+
+func TestCache_GetPut(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "short_urls.json")
+	cache := NewCache(path)
+
+	if err := cache.Put("https://bit.ly/a", CacheEntry{URL: "https://example.com/a"}); err != nil {
+		t.Fatal(err)
+	}
+	old := CacheEntry{URL: "https://example.com/b", ResolvedAt: time.Now().Add(-2 * time.Hour)}
+	if err := cache.Put("https://bit.ly/b", old); err != nil {
+		t.Fatal(err)
+	}
+	failed := CacheEntry{URL: "https://bit.ly/c", Error: "timeout", ResolvedAt: time.Now().Add(-time.Hour)}
+	if err := cache.Put("https://bit.ly/c", failed); err != nil {
+		t.Fatal(err)
+	}
+
+	// A new cache reads what the first one saved.
+	cache = NewCache(path)
+	if entry, ok := cache.Get("https://bit.ly/a", time.Hour); !ok || entry.URL != "https://example.com/a" {
+		t.Errorf("fresh entry: got %+v, %v", entry, ok)
+	}
+	if _, ok := cache.Get("https://bit.ly/b", time.Hour); ok {
+		t.Error("expected entry older than the TTL to be ignored")
+	}
+	if _, ok := cache.Get("https://bit.ly/b", 3*time.Hour); !ok {
+		t.Error("expected entry within a longer TTL to be used")
+	}
+	if _, ok := cache.Get("https://bit.ly/c", 3*time.Hour); ok {
+		t.Error("expected failure older than the negative TTL to be ignored")
+	}
+
+	if list := cache.List(); len(list) != 3 || list[0].ShortURL != "https://bit.ly/a" {
+		t.Errorf("unexpected list: %+v", list)
+	}
+
+	if n, err := cache.Clear(); n != 3 || err != nil {
+		t.Errorf("Clear: got %d, %v", n, err)
+	}
+	if list := NewCache(path).List(); len(list) != 0 {
+		t.Errorf("expected cache to be empty after Clear, got %+v", list)
+	}
+}
+
+func TestCache_MaxEntries(t *testing.T) {
+	cache := NewCache(filepath.Join(t.TempDir(), "short_urls.json"))
+	cache.MaxEntries = 2
+
+	now := time.Now()
+	cache.Put("https://bit.ly/1", CacheEntry{URL: "https://example.com/1", ResolvedAt: now.Add(-3 * time.Minute)})
+	cache.Put("https://bit.ly/2", CacheEntry{URL: "https://example.com/2", ResolvedAt: now.Add(-time.Minute)})
+	cache.Put("https://bit.ly/3", CacheEntry{URL: "https://example.com/3", ResolvedAt: now.Add(-2 * time.Minute)})
+
+	if _, ok := cache.Get("https://bit.ly/1", time.Hour); ok {
+		t.Error("expected the oldest entry to be evicted")
+	}
+	if len(cache.List()) != 2 {
+		t.Errorf("expected 2 entries, got %d", len(cache.List()))
+	}
+}
+
+func TestExpandCached(t *testing.T) {
+	var requests atomic.Int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/short", func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		// Redirect off the shortener host, so the destination page isn't read.
+		http.Redirect(w, r, "http://localhost:"+strings.TrimPrefix(r.Host, "127.0.0.1:")+"/long", http.StatusFound)
+	})
+	mux.HandleFunc("/long", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/broken", func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	cache := NewCache(filepath.Join(t.TempDir(), "short_urls.json"))
+	domains := []string{"127.0.0.1"}
+
+	for i := 0; i < 2; i++ {
+		got, _, err := ExpandCached(server.URL+"/short", domains, cache, time.Hour)
+		if err != nil || !strings.HasSuffix(got, "/long") {
+			t.Fatalf("expand %d: got %q, %v", i, got, err)
+		}
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("expected one request for two expansions, got %d", n)
+	}
+
+	requests.Store(0)
+	for i := 0; i < 2; i++ {
+		if _, _, err := ExpandCached(server.URL+"/broken", domains, cache, time.Hour); err == nil {
+			t.Errorf("expand %d: expected an error for a failing shortener", i)
+		}
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("expected the failure to be cached after HEAD and GET, got %d requests", n)
+	}
+
+	requests.Store(0)
+	ExpandCached(server.URL+"/short", domains, cache, 0)
+	if n := requests.Load(); n != 1 {
+		t.Errorf("expected a zero TTL to bypass the cache, got %d requests", n)
+	}
+}
@@ -0,0 +1,77 @@
+package shorturl
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	metaRefreshTag   = regexp.MustCompile(`(?is)<meta\s[^>]*http-equiv\s*=\s*["']?refresh["']?[^>]*>`)
+	refreshURL       = regexp.MustCompile(`(?is)^\s*\d*(?:\.\d*)?\s*[;,]\s*url\s*=\s*(.+)$`)
+	canonicalLinkTag = regexp.MustCompile(`(?is)<link\s[^>]*rel\s*=\s*["']?canonical["']?[^>]*>`)
+	locationScript   = regexp.MustCompile(`(?s)(?:window\.|document\.|top\.)?location(?:\.href)?\s*=\s*["']([^"']+)["']|location\.(?:replace|assign)\(\s*["']([^"']+)["']\s*\)`)
+)
+
+// htmlRedirect returns the URL a page redirects to without an HTTP redirect:
+// a meta refresh, an assignment to location in a script, or, failing those, a
+// canonical link. Relative URLs are resolved against pageURL. It returns ""
+// when the page has no http(s) redirect target.
+func htmlRedirect(body []byte, pageURL string) string {
+	if len(body) == 0 {
+		return ""
+	}
+	page := string(body)
+
+	var candidates []string
+	for _, tag := range metaRefreshTag.FindAllString(page, -1) {
+		if m := refreshURL.FindStringSubmatch(htmlAttr(tag, "content")); m != nil {
+			candidates = append(candidates, strings.Trim(strings.TrimSpace(m[1]), `'"`))
+		}
+	}
+	for _, m := range locationScript.FindAllStringSubmatch(page, -1) {
+		target := m[1]
+		if target == "" {
+			target = m[2]
+		}
+		// Scripts often escape slashes in strings, as in "https:\/\/example.com".
+		candidates = append(candidates, strings.ReplaceAll(target, `\/`, "/"))
+	}
+	if tag := canonicalLinkTag.FindString(page); tag != "" {
+		candidates = append(candidates, htmlAttr(tag, "href"))
+	}
+
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return ""
+	}
+	for _, candidate := range candidates {
+		if target := resolveTarget(base, html.UnescapeString(candidate)); target != "" {
+			return target
+		}
+	}
+	return ""
+}
+
+// htmlAttr returns the value of the named attribute in an HTML tag.
+func htmlAttr(tag string, name string) string {
+	re := regexp.MustCompile(`(?is)\s` + regexp.QuoteMeta(name) + `\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	m := re.FindStringSubmatch(tag)
+	if m == nil {
+		return ""
+	}
+	return m[1] + m[2] + m[3]
+}
+
+func resolveTarget(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return ""
+	}
+	u, err := base.Parse(ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ""
+	}
+	return u.String()
+}
@@ -4,6 +4,7 @@ import (
 	"embed"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"net/url"
@@ -17,6 +18,17 @@ var shortenerDomainsFS embed.FS
 // Common URL shortener domains
 var shortenerDomains []string
 
+// Defaults for the fields of a Resolver.
+const (
+	DefaultTimeout      = 750 * time.Millisecond
+	DefaultMaxRedirects = 3
+	DefaultUserAgent    = "Finicky/4.0"
+)
+
+// maxBodyPrefix bounds how much of a shortener's page is read when looking
+// for an HTML redirect.
+const maxBodyPrefix = 32 * 1024
+
 func init() {
 	// Load shortener domains from embedded JSON file
 	data, err := shortenerDomainsFS.ReadFile("shortener_domains.json")
@@ -34,104 +46,303 @@ func init() {
 	}
 }
 
+// Hop is a URL in a redirect chain. Status is the HTTP status the URL
+// answered with, and 0 when it wasn't requested or didn't answer.
+type Hop struct {
+	URL    string `json:"url"`
+	Status int    `json:"status,omitempty"`
+}
+
+// String formats the hop for logs, as the URL followed by its status.
+func (h Hop) String() string {
+	if h.Status == 0 {
+		return h.URL
+	}
+	return fmt.Sprintf("%s (%d)", h.URL, h.Status)
+}
+
+// Resolver expands short URLs. The zero value is ready to use, with the
+// defaults described on each field.
+type Resolver struct {
+	// Client makes the requests. Its Timeout and CheckRedirect are replaced
+	// for each request; set a Transport to use a proxy, for example. Nil uses
+	// a client shared by all resolvers, so connections are reused.
+	Client *http.Client
+	// Timeout bounds each request. Zero means DefaultTimeout.
+	Timeout time.Duration
+	// MaxRedirects bounds the redirects followed for one short URL, counting
+	// both HTTP and HTML redirects. Zero means DefaultMaxRedirects.
+	MaxRedirects int
+	// UserAgent is sent with each request. Empty means DefaultUserAgent.
+	UserAgent string
+	// Schemes lists the URL schemes that are requested. A redirect to any
+	// other scheme, such as an app link, ends the chain without requesting
+	// it. Nil means http and https.
+	Schemes []string
+	// NeverExpand lists hosts that aren't expanded even when they are short
+	// URL domains. They match like short URL domains, subdomains included.
+	NeverExpand []string
+}
+
+var (
+	defaultClient   = &http.Client{}
+	defaultResolver = &Resolver{}
+)
+
 // ResolveURL resolves a potentially shortened URL to its final destination by following HTTP redirects, so
-// the matcher can match the final URL instead of the short URL.
-func ResolveURL(originalURL string) (string, error) {
+// the matcher can match the final URL instead of the short URL. It also returns the redirect chain, starting
+// with originalURL and ending with the destination, or nil when originalURL isn't a short URL.
+func ResolveURL(originalURL string) (string, []Hop, error) {
+	return defaultResolver.Expand(originalURL, shortenerDomains)
+}
+
+// DefaultDomains returns a copy of the built-in list of URL shortener domains.
+func DefaultDomains() []string {
+	return append([]string(nil), shortenerDomains...)
+}
+
+// ExpandWithDomains is like ResolveURL, but only URLs on one of domains, or a
+// subdomain of one, are treated as short URLs. An empty list disables
+// expansion.
+func ExpandWithDomains(originalURL string, domains []string) (string, []Hop, error) {
+	return defaultResolver.Expand(originalURL, domains)
+}
+
+// Expand is like ExpandWithDomains, but uses the resolver's client and
+// policy.
+func (r *Resolver) Expand(originalURL string, domains []string) (string, []Hop, error) {
 	parsedURL, err := url.Parse(originalURL)
 	if err != nil {
-		return originalURL, fmt.Errorf("failed to parse URL: %v", err)
+		return originalURL, nil, fmt.Errorf("failed to parse URL: %v", err)
 	}
 
-	// Check if the domain is a known URL shortener
-	isShortURL := false
-	for _, domain := range shortenerDomains {
-		if strings.HasSuffix(parsedURL.Host, domain) {
-			isShortURL = true
-			break
+	if !r.shouldExpand(parsedURL, domains) {
+		return originalURL, nil, nil
+	}
+
+	slog.Debug("URL host looks like a short URL", "host", parsedURL.Host)
+
+	var chain []Hop
+	current := originalURL
+	for {
+		resolvedURL, body, err := r.follow(current, domains, &chain)
+		if err != nil {
+			return lastKnownURL(chain), chain, err
 		}
+
+		target := htmlRedirect(body, resolvedURL)
+		if target == "" || target == resolvedURL {
+			return resolvedURL, chain, nil
+		}
+		slog.Debug("Found HTML redirect", "url", resolvedURL, "target", target)
+		if len(chain) > r.maxRedirects() {
+			chain = append(chain, Hop{URL: target})
+			return target, chain, fmt.Errorf("stopped after %d redirects", r.maxRedirects())
+		}
+		if !r.allowsScheme(target) {
+			chain = append(chain, Hop{URL: target})
+			return target, chain, nil
+		}
+		current = target
 	}
+}
 
-	if !isShortURL {
-		return originalURL, nil
+// shouldExpand reports whether u is a short URL the resolver may request.
+func (r *Resolver) shouldExpand(u *url.URL, domains []string) bool {
+	if !isShortenerHost(u.Hostname(), domains) {
+		return false
 	}
+	if isShortenerHost(u.Hostname(), r.NeverExpand) {
+		slog.Debug("Not expanding URL on a host that is never expanded", "host", u.Host)
+		return false
+	}
+	return r.allowsScheme(u.String())
+}
 
-	slog.Debug("URL host looks like a short URL", "host", parsedURL.Host)
+func (r *Resolver) allowsScheme(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	schemes := r.Schemes
+	if schemes == nil {
+		schemes = []string{"http", "https"}
+	}
+	for _, scheme := range schemes {
+		if strings.EqualFold(u.Scheme, scheme) {
+			return true
+		}
+	}
+	return false
+}
 
-	var lastUrl string
+func (r *Resolver) maxRedirects() int {
+	if r.MaxRedirects > 0 {
+		return r.MaxRedirects
+	}
+	return DefaultMaxRedirects
+}
 
-	// Helper to get the best available URL
-	getReturnUrl := func() string {
-		if lastUrl != "" && lastUrl != originalURL {
-			slog.Debug("Falling back to last known URL from redirects", "url", lastUrl)
-			return lastUrl
+// client returns a copy of the resolver's client that records redirects in
+// chain. The copy shares the original's transport, and so its connections.
+func (r *Resolver) client(chain *[]Hop) *http.Client {
+	base := r.Client
+	if base == nil {
+		base = defaultClient
+	}
+	client := *base
+	client.Timeout = r.Timeout
+	if client.Timeout == 0 {
+		client.Timeout = DefaultTimeout
+	}
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if req.Response != nil {
+			(*chain)[len(*chain)-1].Status = req.Response.StatusCode
+		}
+		lastUrl := req.URL.String()
+		*chain = append(*chain, Hop{URL: lastUrl})
+		slog.Debug("Redirected to", "url", lastUrl)
+		if !r.allowsScheme(lastUrl) {
+			// Leave app links and the like for the browser to open.
+			return http.ErrUseLastResponse
 		}
-		return originalURL
+		if len(*chain) > r.maxRedirects()+1 {
+			return fmt.Errorf("stopped after %d redirects", r.maxRedirects())
+		}
+		return nil
 	}
+	return &client
+}
 
-	// Create a client with a timeout
-	client := &http.Client{
-		Timeout: 750 * time.Millisecond,
-		CheckRedirect: func(req *http.Request, via []*http.Request) error {
-			lastUrl = req.URL.String()
-			slog.Debug("Redirected to", "url", lastUrl)
-			// Allow up to 3 redirects
-			if len(via) >= 3 {
-				return fmt.Errorf("stopped after 3 redirects")
-			}
-			return nil
-		},
+func (r *Resolver) newRequest(method string, rawURL string) (*http.Request, error) {
+	req, err := http.NewRequest(method, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	userAgent := r.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
 	}
+	req.Header.Set("User-Agent", userAgent)
+	return req, nil
+}
+
+// follow requests rawURL, following HTTP redirects, and returns the URL that
+// answered. Each URL requested is added to chain with the status it answered
+// with. A HEAD request is tried first to avoid downloading content; GET is
+// used when that fails, or to read the start of the page when the answer
+// still comes from a shortener, which may redirect with HTML instead. The
+// page is only returned in that case.
+func (r *Resolver) follow(rawURL string, domains []string, chain *[]Hop) (string, []byte, error) {
+	start := len(*chain)
+	*chain = append(*chain, Hop{URL: rawURL})
+	client := r.client(chain)
 
 	// Make a HEAD request first to follow redirects without downloading content
-	req, err := http.NewRequest("HEAD", originalURL, nil)
+	req, err := r.newRequest("HEAD", rawURL)
 	if err != nil {
-		return originalURL, fmt.Errorf("failed to create request: %v", err)
+		return "", nil, fmt.Errorf("failed to create request: %v", err)
 	}
-	req.Header.Set("User-Agent", "Finicky/4.0")
 
 	resp, err := client.Do(req)
 
 	if err != nil {
-		slog.Debug("Failed to make HEAD request", "url", originalURL, "error", err)
+		slog.Debug("Failed to make HEAD request", "url", rawURL, "error", err)
 	}
 
 	if resp != nil {
-		// If we got a successful response, return the final URL
-		if resp.StatusCode == http.StatusOK {
-			slog.Debug("Got a successful response", "url", resp.Request.URL.String())
-			return resp.Request.URL.String(), nil
+		resp.Body.Close()
+		if target, ok := r.disallowedTarget(*chain, resp); ok {
+			return target, nil, nil
 		}
-
-		if resp.Body != nil {
-			resp.Body.Close()
+		// If we got a successful response from the destination, return its URL
+		if resp.StatusCode == http.StatusOK && !isShortenerHost(resp.Request.URL.Hostname(), domains) {
+			slog.Debug("Got a successful response", "url", resp.Request.URL.String())
+			(*chain)[len(*chain)-1].Status = resp.StatusCode
+			return resp.Request.URL.String(), nil, nil
 		}
 	}
 
-	// If HEAD request failed, try GET as fallback
-	req, err = http.NewRequest("GET", originalURL, nil)
+	// Use GET, which follows the redirects again
+	*chain = append((*chain)[:start], Hop{URL: rawURL})
+	req, err = r.newRequest("GET", rawURL)
 	if err != nil {
-		return getReturnUrl(), fmt.Errorf("failed to create GET request: %v", err)
+		return "", nil, fmt.Errorf("failed to create GET request: %v", err)
 	}
-	req.Header.Set("User-Agent", "Finicky/4.0")
 
 	resp, err = client.Do(req)
 
 	if err != nil {
-		return getReturnUrl(), fmt.Errorf("failed to make GET request: %v", err)
+		return "", nil, fmt.Errorf("failed to make GET request: %v", err)
 	}
+	defer resp.Body.Close()
 
-	if resp != nil {
-		if resp.StatusCode == http.StatusOK {
-			slog.Debug("Got a successful response", "url", resp.Request.URL.String())
-			return resp.Request.URL.String(), nil
-		}
+	if target, ok := r.disallowedTarget(*chain, resp); ok {
+		return target, nil, nil
+	}
+	(*chain)[len(*chain)-1].Status = resp.StatusCode
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("failed to resolve URL: no response received")
+	}
 
-		if resp.Body != nil {
-			resp.Body.Close()
-		}
+	finalURL := resp.Request.URL.String()
+	slog.Debug("Got a successful response", "url", finalURL)
+	if !isShortenerHost(resp.Request.URL.Hostname(), domains) {
+		return finalURL, nil, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBodyPrefix))
+	if err != nil {
+		slog.Debug("Failed to read response body", "url", finalURL, "error", err)
+	}
+	return finalURL, body, nil
+}
+
+// disallowedTarget returns the URL resp redirected to when the resolver
+// stopped there because of its scheme.
+func (r *Resolver) disallowedTarget(chain []Hop, resp *http.Response) (string, bool) {
+	last := chain[len(chain)-1].URL
+	if last == resp.Request.URL.String() || r.allowsScheme(last) {
+		return "", false
+	}
+	return last, true
+}
+
+// lastKnownURL returns the last URL in chain, which starts with the original
+// URL.
+func lastKnownURL(chain []Hop) string {
+	last := chain[len(chain)-1].URL
+	if len(chain) > 1 {
+		slog.Debug("Falling back to last known URL from redirects", "url", last)
 	}
+	return last
+}
 
-	// If both HEAD and GET failed, return original URL
-	return getReturnUrl(), fmt.Errorf("failed to resolve URL: no response received")
+// IsShortURL reports whether rawURL is on one of domains, or a subdomain of
+// one.
+func IsShortURL(rawURL string, domains []string) bool {
+	parsedURL, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+	return isShortenerHost(parsedURL.Hostname(), domains)
+}
 
+// isShortenerHost reports whether host is one of domains or a subdomain of
+// one. Domains may be written with a leading "*." or ".".
+func isShortenerHost(host string, domains []string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "" {
+		return false
+	}
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		domain = strings.TrimPrefix(strings.TrimPrefix(domain, "*"), ".")
+		if domain == "" {
+			continue
+		}
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
 }
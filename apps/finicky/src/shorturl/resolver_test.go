@@ -0,0 +1,167 @@
+package shorturl_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	. "finicky/shorturl"
+)
+
+func TestExpandWithDomains_HostMatching(t *testing.T) {
+	// None of these hosts are short URL hosts, so no request is made.
+	for _, url := range []string{
+		"https://notbit.ly/abc",
+		"https://bit.ly.example.com/abc",
+		"https://example.com/bit.ly",
+	} {
+		got, hops, err := ExpandWithDomains(url, []string{"bit.ly"})
+		if got != url || hops != nil || err != nil {
+			t.Errorf("%s: got %q %v %v, want it unchanged", url, got, hops, err)
+		}
+	}
+
+	got, _, err := ExpandWithDomains("https://bit.ly/abc", []string{})
+	if got != "https://bit.ly/abc" || err != nil {
+		t.Errorf("empty list: got %q %v, want expansion disabled", got, err)
+	}
+}
+
+func TestExpandWithDomains_FollowsRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/short", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/long", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/long", func(w http.ResponseWriter, r *http.Request) {})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	got, chain, err := ExpandWithDomains(server.URL+"/short", []string{"127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Hop{
+		{URL: server.URL + "/short", Status: http.StatusMovedPermanently},
+		{URL: server.URL + "/long", Status: http.StatusOK},
+	}
+	if got != server.URL+"/long" || !reflect.DeepEqual(chain, want) {
+		t.Errorf("got %q %v, want chain %v", got, chain, want)
+	}
+}
+
+func TestExpandWithDomains_HTMLRedirects(t *testing.T) {
+	pages := map[string]string{
+		"/meta":       `<html><head><meta http-equiv="refresh" content="0; URL='/dest?a=1&amp;b=2'"></head></html>`,
+		"/meta-order": `<META CONTENT="0;url=https://example.com/" HTTP-EQUIV="Refresh">`,
+		"/script":     `<head><noscript><title>x</title></noscript></head><script>window.opener = null; location.replace("\/dest")</script>`,
+		"/canonical":  `<html><head><link rel="canonical" href="/dest"></head></html>`,
+		"/self":       `<html><head><link rel="canonical" href="/self"></head></html>`,
+		"/not-web":    `<meta http-equiv="refresh" content="0;url=javascript:alert(1)">`,
+		"/chain":      `<meta http-equiv="refresh" content="0;url=/redirect">`,
+		"/loop":       `<meta http-equiv="refresh" content="0;url=/loop2">`,
+		"/loop2":      `<meta http-equiv="refresh" content="0;url=/loop3">`,
+		"/loop3":      `<meta http-equiv="refresh" content="0;url=/loop4">`,
+		"/loop4":      `<meta http-equiv="refresh" content="0;url=/loop">`,
+		"/dest":       `<html><body>Destination</body></html>`,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		page, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(page))
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/dest", http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	domains := []string{"127.0.0.1"}
+
+	cases := []struct {
+		path    string
+		want    string
+		hops    int
+		wantErr bool
+	}{
+		{"/meta", server.URL + "/dest?a=1&b=2", 1, false},
+		{"/meta-order", "https://example.com/", 1, true}, // example.com isn't reachable
+		{"/script", server.URL + "/dest", 1, false},
+		{"/canonical", server.URL + "/dest", 1, false},
+		{"/self", server.URL + "/self", 0, false},
+		{"/not-web", server.URL + "/not-web", 0, false},
+		{"/chain", server.URL + "/dest", 2, false},
+		{"/loop", server.URL + "/loop", 4, true},
+	}
+	for _, c := range cases {
+		got, chain, err := ExpandWithDomains(server.URL+c.path, domains)
+		if c.path == "/meta-order" {
+			// Only check that the target was found; following it needs the network.
+			if len(chain) < 2 || chain[1].URL != c.want {
+				t.Errorf("%s: got chain %v, want %q second", c.path, chain, c.want)
+			}
+			continue
+		}
+		if got != c.want || len(chain) != c.hops+1 || (err != nil) != c.wantErr {
+			t.Errorf("%s: got %q %v %v, want %q with %d hops", c.path, got, chain, err, c.want, c.hops)
+		}
+	}
+}
+
+func TestResolver_Policy(t *testing.T) {
+	var userAgent string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/short", func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.Header.Get("User-Agent")
+		http.Redirect(w, r, "/long", http.StatusFound)
+	})
+	mux.HandleFunc("/long", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/twice", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/short", http.StatusFound)
+	})
+	mux.HandleFunc("/app", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "zoommtg://zoom.us/join?confno=123", http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	domains := []string{"127.0.0.1"}
+
+	r := &Resolver{Client: server.Client(), UserAgent: "Test/1.0"}
+	got, _, err := r.Expand(server.URL+"/short", domains)
+	if err != nil || got != server.URL+"/long" {
+		t.Errorf("got %q %v", got, err)
+	}
+	if userAgent != "Test/1.0" {
+		t.Errorf("user agent: got %q", userAgent)
+	}
+
+	r = &Resolver{MaxRedirects: 1}
+	if _, _, err := r.Expand(server.URL+"/twice", domains); err == nil {
+		t.Error("expected an error past MaxRedirects")
+	}
+
+	r = &Resolver{NeverExpand: []string{"127.0.0.1"}}
+	if got, chain, err := r.Expand(server.URL+"/short", domains); got != server.URL+"/short" || chain != nil || err != nil {
+		t.Errorf("never expand: got %q %v %v", got, chain, err)
+	}
+
+	r = &Resolver{Schemes: []string{"https"}}
+	if got, chain, _ := r.Expand(server.URL+"/short", domains); got != server.URL+"/short" || chain != nil {
+		t.Errorf("disallowed scheme: got %q %v", got, chain)
+	}
+
+	// A redirect to an app link ends the chain without requesting it.
+	r = &Resolver{}
+	got, chain, err := r.Expand(server.URL+"/app", domains)
+	want := []Hop{
+		{URL: server.URL + "/app", Status: http.StatusFound},
+		{URL: "zoommtg://zoom.us/join?confno=123"},
+	}
+	if err != nil || got != "zoommtg://zoom.us/join?confno=123" || !reflect.DeepEqual(chain, want) {
+		t.Errorf("app link: got %q %v %v", got, chain, err)
+	}
+}
@@ -0,0 +1,121 @@
+package shorturl
+
+import (
+	"embed"
+	"encoding/json"
+	"log/slog"
+	"net/url"
+	"strings"
+)
+
+//go:embed redirect_wrappers.json
+var redirectWrappersFS embed.FS
+
+// maxUnwraps bounds how many wrappers Unwrap removes from one URL.
+const maxUnwraps = 10
+
+// redirectWrapper describes a service that wraps links in its own URL and
+// carries the destination in a query parameter.
+type redirectWrapper struct {
+	Name string `json:"name"`
+	// Hosts match exactly or as a parent domain.
+	Hosts []string `json:"hosts"`
+	// Paths match exactly, or by prefix when they end in *. Any path matches
+	// when there are none.
+	Paths []string `json:"paths,omitempty"`
+	// Params are checked in order; the first one holding an http(s) URL is
+	// the destination.
+	Params []string `json:"params"`
+}
+
+var redirectWrappers []redirectWrapper
+
+func init() {
+	data, err := redirectWrappersFS.ReadFile("redirect_wrappers.json")
+	if err != nil {
+		slog.Error("Failed to read redirect wrappers file", "error", err)
+		redirectWrappers = []redirectWrapper{}
+		return
+	}
+	if err := json.Unmarshal(data, &redirectWrappers); err != nil {
+		slog.Error("Failed to parse redirect wrappers JSON", "error", err)
+		redirectWrappers = []redirectWrapper{}
+	}
+}
+
+// Unwrap returns the destination of a redirect wrapper URL, such as an
+// Outlook SafeLink or a Google search result link, without making any
+// requests. Wrappers nested in each other are all removed. The second return
+// value lists the URLs that were unwrapped to, in order; it is empty when
+// originalURL isn't a wrapper.
+func Unwrap(originalURL string) (string, []string) {
+	var chain []string
+	current := originalURL
+	for len(chain) < maxUnwraps {
+		next, name, ok := unwrapOnce(current)
+		if !ok {
+			break
+		}
+		slog.Debug("Unwrapped redirect wrapper", "wrapper", name, "url", next)
+		chain = append(chain, next)
+		current = next
+	}
+	return current, chain
+}
+
+func unwrapOnce(rawURL string) (string, string, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", "", false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, w := range redirectWrappers {
+		if !w.matches(host, u.Path) {
+			continue
+		}
+		query := u.Query()
+		for _, param := range w.Params {
+			if target := query.Get(param); isWebURL(target) {
+				return target, w.Name, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+func (w redirectWrapper) matches(host string, path string) bool {
+	hostMatches := false
+	for _, h := range w.Hosts {
+		if host == h || strings.HasSuffix(host, "."+h) {
+			hostMatches = true
+			break
+		}
+	}
+	if !hostMatches {
+		return false
+	}
+	if len(w.Paths) == 0 {
+		return true
+	}
+	if path == "" {
+		path = "/"
+	}
+	for _, p := range w.Paths {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		} else if path == p {
+			return true
+		}
+	}
+	return false
+}
+
+func isWebURL(s string) bool {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return u.Scheme == "http" || u.Scheme == "https"
+}
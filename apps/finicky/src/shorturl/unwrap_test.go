@@ -0,0 +1,90 @@
+package shorturl_test
+
+import (
+	"reflect"
+	"testing"
+
+	. "finicky/shorturl"
+)
+
+func TestUnwrap(t *testing.T) {
+	cases := []struct {
+		name  string
+		input string
+		want  string
+		chain []string
+	}{
+		{
+			"outlook safelinks",
+			"https://nam12.safelinks.protection.outlook.com/?url=https%3A%2F%2Fexample.com%2Fpath%3Fa%3D1&data=05%7C01&reserved=0",
+			"https://example.com/path?a=1",
+			[]string{"https://example.com/path?a=1"},
+		},
+		{
+			"google",
+			"https://www.google.com/url?sa=t&q=https://example.com/&usg=abc",
+			"https://example.com/",
+			[]string{"https://example.com/"},
+		},
+		{
+			"slack",
+			"https://slack-redirect.slack.com/link?url=https%3A%2F%2Fexample.com%2F&v=3",
+			"https://example.com/",
+			[]string{"https://example.com/"},
+		},
+		{
+			"facebook",
+			"https://l.facebook.com/l.php?u=https%3A%2F%2Fexample.com%2F&h=AT0",
+			"https://example.com/",
+			[]string{"https://example.com/"},
+		},
+		{
+			"linkedin",
+			"https://www.linkedin.com/redir/redirect?url=https%3A%2F%2Fexample.com%2F&urlhash=x",
+			"https://example.com/",
+			[]string{"https://example.com/"},
+		},
+		{
+			"teams",
+			"https://statics.teams.cdn.office.net/evergreen-assets/safelinks/1/atp-safelinks.html?url=https%3A%2F%2Fexample.com%2F",
+			"https://example.com/",
+			[]string{"https://example.com/"},
+		},
+		{
+			"nested",
+			"https://eur01.safelinks.protection.outlook.com/?url=https%3A%2F%2Fwww.google.com%2Furl%3Fq%3Dhttps%253A%252F%252Fexample.com%252F",
+			"https://example.com/",
+			[]string{"https://www.google.com/url?q=https%3A%2F%2Fexample.com%2F", "https://example.com/"},
+		},
+		{
+			"not a wrapper",
+			"https://example.com/?url=https://other.example/",
+			"https://example.com/?url=https://other.example/",
+			nil,
+		},
+		{
+			"wrong path",
+			"https://www.google.com/search?q=https://example.com/",
+			"https://www.google.com/search?q=https://example.com/",
+			nil,
+		},
+		{
+			"destination isn't a web URL",
+			"https://www.google.com/url?q=javascript:alert(1)",
+			"https://www.google.com/url?q=javascript:alert(1)",
+			nil,
+		},
+		{
+			"lookalike host",
+			"https://notgoogle.com/url?q=https://example.com/",
+			"https://notgoogle.com/url?q=https://example.com/",
+			nil,
+		},
+	}
+	for _, c := range cases {
+		got, chain := Unwrap(c.input)
+		if got != c.want || !reflect.DeepEqual(chain, c.chain) {
+			t.Errorf("%s: got %q %q, want %q %q", c.name, got, chain, c.want, c.chain)
+		}
+	}
+}
@@ -15,7 +15,6 @@ import (
 	"finicky/util"
 
 	"github.com/Masterminds/semver"
-	"github.com/dop251/goja"
 )
 
 const updateCheckInterval = 24 * time.Hour
@@ -234,8 +233,9 @@ func checkForUpdates() (releaseInfo *ReleaseInfo) {
 	return releaseInfo
 }
 
-// CheckForUpdatesIfEnabled checks if updates should be performed based on VM configuration
-func CheckForUpdatesIfEnabled(vm *goja.Runtime) (releaseInfo *ReleaseInfo, updateCheckEnabled bool, err error) {
+// CheckForUpdatesIfEnabled checks for updates when the checkForUpdates config
+// option is enabled. The returned bool reports whether update checks are enabled.
+func CheckForUpdatesIfEnabled(enabled bool) (releaseInfo *ReleaseInfo, updateCheckEnabled bool) {
 	if mockVersion := os.Getenv("FINICKY_MOCK_UPDATE"); mockVersion != "" {
 		slog.Info("FINICKY_MOCK_UPDATE set, returning mock update", "version", mockVersion)
 		mockTag := strings.TrimPrefix(mockVersion, "v")
@@ -244,28 +244,16 @@ func CheckForUpdatesIfEnabled(vm *goja.Runtime) (releaseInfo *ReleaseInfo, updat
 			LatestVersion: mockVersion,
 			DownloadUrl:   fmt.Sprintf("https://github.com/johnste/finicky/releases/tag/v%s", mockTag),
 			ReleaseUrl:    fmt.Sprintf("https://github.com/johnste/finicky/releases/tag/v%s", mockTag),
-		}, true, nil
+		}, true
 	}
 
-	if vm == nil {
-		// Check for updates if we don't have a VM
+	if enabled {
 		releaseInfo := checkForUpdates()
-		return releaseInfo, true, nil
-	}
-
-	// Check checkForUpdates option
-	shouldCheckForUpdates, err := vm.RunString("finickyConfigAPI.getOption('checkForUpdates', finalConfig, true)")
-	if err != nil {
-		return nil, true, fmt.Errorf("failed to get checkForUpdates option: %v", err)
-	}
-
-	if shouldCheckForUpdates.ToBoolean() {
-		releaseInfo := checkForUpdates()
-		return releaseInfo, true, nil
+		return releaseInfo, true
 	} else {
 		slog.Debug("Skipping update check")
 	}
-	return nil, false, nil
+	return nil, false
 }
 
 // isUpdateAvailable checks if the latest version is newer than the current version
@@ -13,7 +13,7 @@ const { matchHostnames } = finicky;
 export default {
   defaultBrowser: { name: "Firefox" },
   options: {
-    urlShorteners: [],
+    urlShorteners: (domains) => [...domains, "example.link"],
     logRequests: true,
   },
   rewrite: [
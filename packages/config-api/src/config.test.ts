@@ -1,5 +1,5 @@
 import { describe, it, expect, vi } from "vitest";
-import { openUrl } from "./index";
+import { openUrl, validateConfig, getTests, TraceStep } from "./index";
 import { Config, ProcessInfo } from "./configSchema";
 
 describe("openUrl", () => {
@@ -27,6 +27,10 @@ describe("openUrl", () => {
           match: ["figma.com*", "sketch.com*"],
           browser: { name: "Google Chrome", profile: "Design" },
         },
+        {
+          match: "mail.google.com*",
+          browser: { name: "Google Chrome", appWindow: true, private: true },
+        },
         {
           match: (url: URL) => url.pathname.includes("docs"),
           browser: "Google Chrome",
@@ -47,6 +51,10 @@ describe("openUrl", () => {
         url: "https://sketch.com/dashboard",
         expected: { name: "Google Chrome", profile: "Design" },
       },
+      {
+        url: "https://mail.google.com/mail",
+        expected: { name: "Google Chrome", appWindow: true, private: true },
+      },
       { url: "https://example.com/docs", expected: "Google Chrome" },
     ];
 
@@ -59,6 +67,20 @@ describe("openUrl", () => {
       });
     });
 
+    it("passes browsers after the first as fallbacks", () => {
+      const result = openUrl("https://example.com", null, null, {
+        defaultBrowser: ["Arc", { name: "Google Chrome", profile: "Work" }, () => "Safari"],
+      });
+      expect(result.browser).toMatchObject({
+        name: "Arc",
+        url: "https://example.com/",
+        fallbacks: [
+          { name: "Google Chrome", profile: "Work", url: "https://example.com/" },
+          { name: "Safari", url: "https://example.com/" },
+        ],
+      });
+    });
+
     it("works with null opener", () => {
       const result = openUrl("https://example.com", null, null, handlerConfig);
       expect(result.browser).toMatchObject({ name: "Firefox" });
@@ -180,3 +202,134 @@ describe("openUrl", () => {
     });
   });
 });
+
+describe("tests", () => {
+  const testConfig = {
+    defaultBrowser: "Safari",
+    handlers: [{ match: "github.com*", browser: "Google Chrome" }],
+    tests: [
+      {
+        url: "https://github.com/johnste/finicky",
+        opener: { bundleId: "com.tinyspeck.slackmacgap" },
+        expect: { browser: "Google Chrome" },
+      },
+      { name: "default", url: "https://example.com", expect: {} },
+    ],
+  };
+
+  it("accepts routing tests in the config", () => {
+    expect(validateConfig(testConfig)).toBe(true);
+  });
+
+  it("rejects tests without a url", () => {
+    expect(
+      validateConfig({ defaultBrowser: "Safari", tests: [{ expect: {} }] })
+    ).toBe(false);
+  });
+
+  it("returns the tests", () => {
+    expect(getTests(testConfig)).toHaveLength(2);
+    expect(getTests({ defaultBrowser: "Safari" })).toEqual([]);
+  });
+
+  it("does not affect url handling", () => {
+    const result = openUrl("https://github.com/foo", null, null, testConfig);
+    expect(result.browser).toMatchObject({ name: "Google Chrome" });
+  });
+});
+
+describe("trace", () => {
+  const traceConfig = {
+    defaultBrowser: "Safari",
+    rewrite: [
+      {
+        match: "https://t.co/*",
+        url: (url: URL) => url.href.replace("t.co", "twitter.com"),
+      },
+    ],
+    handlers: [
+      { match: "https://github.com/*", browser: "Firefox" },
+      { match: "https://twitter.com/*", browser: "Google Chrome" },
+    ],
+  };
+
+  it("records each step and the matching handler", () => {
+    const trace = { now: () => 0, steps: [] as TraceStep[] };
+    const result = openUrl("https://t.co/abc", null, null, traceConfig, trace);
+    expect(result.browser).toMatchObject({ name: "Google Chrome" });
+    expect(trace.steps).toEqual([
+      {
+        kind: "rewrite",
+        index: 0,
+        matched: true,
+        url: "https://twitter.com/abc",
+        durationMs: 0,
+      },
+      { kind: "handler", index: 0, matched: false, durationMs: 0 },
+      { kind: "handler", index: 1, matched: true, durationMs: 0 },
+    ]);
+  });
+
+  it("records the default browser when no handler matches", () => {
+    const trace = { now: () => 0, steps: [] as TraceStep[] };
+    openUrl("https://example.com", null, null, traceConfig, trace);
+    expect(trace.steps.map((step) => step.kind)).toEqual([
+      "rewrite",
+      "handler",
+      "handler",
+      "defaultBrowser",
+    ]);
+  });
+});
+
+describe("url chain", () => {
+  it("passes the first url of the chain as originalUrl", () => {
+    const result = openUrl(
+      "https://example.com/destination",
+      null,
+      [
+        { url: "https://nam12.safelinks.protection.outlook.com/?url=https%3A%2F%2Fwww.google.com%2Furl%3Fq%3Dhttps%3A%2F%2Fexample.com%2Fdestination" },
+        { url: "https://www.google.com/url?q=https://example.com/destination" },
+        { url: "https://example.com/destination" },
+      ],
+      {
+        defaultBrowser: "Safari",
+        handlers: [
+          {
+            match: (url: URL, { originalUrl, urlChain }: any) =>
+              originalUrl.host === "nam12.safelinks.protection.outlook.com" &&
+              urlChain.length === 3 &&
+              url.host === "example.com",
+            browser: "Firefox",
+          },
+        ],
+      }
+    );
+    expect(result.browser).toMatchObject({ name: "Firefox" });
+  });
+
+  it("passes the status of each hop in redirectChain", () => {
+    const result = openUrl(
+      "https://example.com/destination",
+      null,
+      [
+        { url: "https://bit.ly/abc", status: 301 },
+        { url: "https://tracker.example.net/click?id=1", status: 302 },
+        { url: "https://example.com/destination", status: 200 },
+      ],
+      {
+        defaultBrowser: "Safari",
+        handlers: [
+          {
+            match: (url: URL, { redirectChain }: any) =>
+              redirectChain.some(
+                (hop: any) => hop.url.host === "tracker.example.net" && hop.status === 302
+              ),
+            browser: "Firefox",
+          },
+        ],
+      }
+    );
+    expect(result.browser).toMatchObject({ name: "Firefox" });
+  });
+});
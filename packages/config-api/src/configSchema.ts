@@ -41,6 +41,26 @@ const OpenUrlOptionsSchema = z
   .object({
     opener: ProcessInfoSchema.nullable(),
     originalUrl: NativeUrlSchema.optional(),
+    urlChain: z
+      .array(NativeUrlSchema)
+      .optional()
+      .describe(
+        "The URLs the url was unwrapped or expanded from, starting with originalUrl and ending with the url"
+      ),
+    redirectChain: z
+      .array(
+        z.object({
+          url: NativeUrlSchema,
+          status: z
+            .number()
+            .optional()
+            .describe("The HTTP status the URL answered with, missing when it wasn't requested"),
+        })
+      )
+      .optional()
+      .describe(
+        "Like urlChain, with the HTTP status of each URL that was requested while expanding a short URL"
+      ),
   })
   .identifier("OpenUrlOptions");
 
@@ -84,7 +104,20 @@ const BrowserConfigSchema = z
     appType: z.enum(appTypes).optional(),
     openInBackground: z.boolean().optional(),
     profile: z.string().optional(),
-    args: z.array(z.string()).optional(),
+    args: z
+      .array(z.string())
+      .optional()
+      .describe(
+        "Arguments to open the browser with. {url}, {host}, {path}, {query} and {profileDir} are replaced, and the url is added after the arguments unless they contain it"
+      ),
+    private: z
+      .boolean()
+      .optional()
+      .describe("Open the url in a private or incognito window"),
+    appWindow: z
+      .boolean()
+      .optional()
+      .describe("Open the url in a window without browser UI (Chromium browsers only)"),
   })
   .identifier("BrowserConfig")
   .describe("A browser or app to open for urls");
@@ -95,6 +128,8 @@ export const BrowserConfigStrictSchema = z.object({
   openInBackground: z.boolean().optional(),
   profile: z.string(),
   args: z.array(z.string()),
+  private: z.boolean().optional(),
+  appWindow: z.boolean().optional(),
   url: z.string(),
 });
 
@@ -104,7 +139,18 @@ const BrowserResolverSchema = z
   .identifier("BrowserResolver");
 
 export const BrowserSpecificationSchema = z
-  .union([z.null(), z.string(), BrowserConfigSchema, BrowserResolverSchema])
+  .union([
+    z.null(),
+    z.string(),
+    BrowserConfigSchema,
+    BrowserResolverSchema,
+    z
+      .array(z.union([z.string(), BrowserConfigSchema, BrowserResolverSchema]))
+      .min(1)
+      .describe(
+        "Browsers to try in order. One that isn't installed or fails to open is skipped for the next"
+      ),
+  ])
   .identifier("BrowserSpecification");
 
 // ===== Rule Schemas =====
@@ -128,14 +174,59 @@ const HandlerRuleSchema = z
   )
   .identifier("BrowserHandler");
 
+// ===== Test Schemas =====
+const RoutingTestSchema = z
+  .object({
+    name: z.string().optional(),
+    url: z.string(),
+    opener: ProcessInfoSchema.partial().optional(),
+    openInBackground: z.boolean().optional(),
+    expect: z
+      .object({
+        browser: z.string().optional(),
+        profile: z.string().optional(),
+        url: z.string().optional(),
+      })
+      .describe("The expected result. Only the fields that are set are checked."),
+  })
+  .describe(
+    "A routing test that asserts where a url is opened. Run the tests with `finicky test`."
+  )
+  .identifier("RoutingTest");
+
 // ===== Configuration Schemas =====
 const ConfigOptionsSchema = z
   .object({
-    urlShorteners: z.array(z.string()).optional(),
+    urlShorteners: z
+      .union([
+        z.array(z.string()),
+        z
+          .function(z.tuple([z.array(z.string())]))
+          .returns(z.array(z.string())),
+      ])
+      .optional()
+      .describe(
+        "Domains whose short urls are expanded before matching. A list replaces the built-in domains, and an empty list disables expansion. A function receives the built-in domains and returns the list to use, e.g. (domains) => [...domains, \"example.link\"]"
+      ),
     logRequests: z.boolean().optional().describe("Log to file on disk"),
     checkForUpdates: z.boolean().optional().describe("Check for updates"),
     keepRunning: z.boolean().optional().describe("Keep the app running"),
-    hideIcon: z.boolean().optional().describe("Hide the app icon")
+    hideIcon: z.boolean().optional().describe("Hide the app icon"),
+    evaluationTimeoutMs: z
+      .number()
+      .int()
+      .positive()
+      .optional()
+      .describe(
+        "Maximum time in milliseconds the config may spend deciding where to open a url. Defaults to 2000."
+      ),
+    shortUrlCacheTtlHours: z
+      .number()
+      .nonnegative()
+      .optional()
+      .describe(
+        "How many hours an expanded short url is remembered, so it opens without a network request. 0 disables the cache. Defaults to 168 (a week)."
+      ),
   })
   .identifier("ConfigOptions");
 
@@ -160,6 +251,10 @@ export const ConfigSchema = z
       .describe(
         "An array of handlers to select which browser or app to open for urls"
       ),
+    tests: z
+      .array(RoutingTestSchema)
+      .optional()
+      .describe("An array of routing tests, run with `finicky test`"),
   })
   .describe(
     `
@@ -190,7 +285,10 @@ export type UrlTransformer = z.infer<typeof UrlTransformerSchema>;
 export type UrlMatcherFunction = z.infer<typeof UrlMatcherFunctionSchema>;
 export type UrlMatcher = z.infer<typeof UrlMatcherSchema>;
 export type UrlMatcherPattern = z.infer<typeof UrlMatcherPatternSchema>;
-export type BrowserConfigStrict = z.infer<typeof BrowserConfigStrictSchema>;
+export type BrowserConfigStrict = z.infer<typeof BrowserConfigStrictSchema> & {
+  /** Browsers to try in order when this one isn't installed or fails to open */
+  fallbacks?: BrowserConfigStrict[];
+};
 
 export type BrowserConfig = z.infer<typeof BrowserConfigSchema>;
 export type BrowserResolver = z.infer<typeof BrowserResolverSchema>;
@@ -199,5 +297,7 @@ export type BrowserSpecification = z.infer<typeof BrowserSpecificationSchema>;
 export type RewriteRule = z.infer<typeof RewriteRuleSchema>;
 export type HandlerRule = z.infer<typeof HandlerRuleSchema>;
 
+export type RoutingTest = z.infer<typeof RoutingTestSchema>;
+
 export type ConfigOptions = z.infer<typeof ConfigOptionsSchema>;
 export type Config = z.infer<typeof ConfigSchema>;
@@ -79,12 +79,57 @@ export function getConfigState(config: Config) {
   };
 }
 
+export function getTests(config: Config) {
+  return config.tests || [];
+}
+
+type StepKind = "rewrite" | "handler" | "defaultBrowser";
+
+/**
+ * The step of openUrl that is running. The host reads it after interrupting an
+ * evaluation that ran over its time budget, to report where it stopped.
+ */
+let currentStep: { kind: StepKind; index: number } | null = null;
+
+export function getCurrentStep() {
+  return currentStep;
+}
+
+/** A rewrite, handler or default browser that openUrl evaluated */
+export interface TraceStep {
+  kind: StepKind;
+  index: number;
+  matched: boolean;
+  /** The url after a matching rewrite */
+  url?: string;
+  durationMs: number;
+}
+
+/** A URL in the redirect chain passed to openUrl, with the HTTP status it answered with */
+export interface RedirectHop {
+  url: string;
+  status?: number;
+}
+
+/** Collects the steps of openUrl. now returns a timestamp in milliseconds. */
+export interface OpenUrlTrace {
+  now: () => number;
+  steps: TraceStep[];
+}
+
 export function openUrl(
   urlString: string,
   opener: ProcessInfo | null,
-  originalUrlString: string | null,
-  config: object
+  originalUrlString: string | RedirectHop[] | null,
+  config: object,
+  trace?: OpenUrlTrace | null
 ) {
+  currentStep = null;
+  const now = () => (trace ? trace.now() : 0);
+  const record = (step: Omit<TraceStep, "durationMs">, start: number) => {
+    trace?.steps.push({ ...step, durationMs: now() - start });
+  };
+
   try {
   if (!validateConfig(config)) {
     throw new Error("Invalid config");
@@ -96,7 +141,17 @@ export function openUrl(
     opener: opener,
   };
 
-  if (originalUrlString) {
+  // An array is the redirect chain the url was unwrapped or expanded from,
+  // starting with the URL that was opened.
+  if (Array.isArray(originalUrlString)) {
+    const redirectChain = originalUrlString.map((hop) => ({
+      url: new FinickyURL(hop.url, opener),
+      status: hop.status,
+    }));
+    options.originalUrl = redirectChain[0].url;
+    options.urlChain = redirectChain.map((hop) => hop.url);
+    options.redirectChain = redirectChain;
+  } else if (originalUrlString) {
     options.originalUrl = new FinickyURL(originalUrlString, opener);
   }
 
@@ -104,27 +159,42 @@ export function openUrl(
 
   try {
     if (config.rewrite) {
-      for (const rewrite of config.rewrite) {
-        if (isMatch(rewrite.match, url, options)) {
+      for (const [index, rewrite] of config.rewrite.entries()) {
+        currentStep = { kind: "rewrite", index };
+        const start = now();
+        const matched = isMatch(rewrite.match, url, options);
+        if (matched) {
           url = rewriteUrl(rewrite.url, url, options);
         }
+        record(
+          { kind: "rewrite", index, matched, url: matched ? url.href : undefined },
+          start
+        );
       }
     }
 
     if (config.handlers) {
       for (const [index, handler] of config.handlers.entries()) {
+        currentStep = { kind: "handler", index };
+        const start = now();
         if (isMatch(handler.match, url, options)) {
+          const browser = resolveBrowser(handler.browser, url, options);
+          record({ kind: "handler", index, matched: true }, start);
           return {
-            browser: resolveBrowser(handler.browser, url, options),
+            browser,
           };
         }
+        record({ kind: "handler", index, matched: false }, start);
       }
     }
   } catch (ex: unknown) {
     error = ex instanceof Error ? ex.message : String(ex);
   }
 
+  currentStep = { kind: "defaultBrowser", index: 0 };
+  const start = now();
   const browser = resolveBrowser(config.defaultBrowser, url, options);
+  record({ kind: "defaultBrowser", index: 0, matched: true }, start);
 
   return {
     browser,
@@ -189,6 +259,13 @@ export function resolveBrowser(
   url: URL | FinickyURL,
   options: OpenUrlOptions
 ): BrowserConfigStrict {
+  // A list is the browser to open followed by its fallbacks. An empty list
+  // is reported as invalid below.
+  if (Array.isArray(browser) && browser.length > 0) {
+    const [first, ...fallbacks] = browser.map((b) => resolveBrowser(b, url, options));
+    return fallbacks.length > 0 ? { ...first, fallbacks } : first;
+  }
+
   const config =
     typeof browser === "function" ? browser(url, options) : browser;
 
@@ -1,10 +1,37 @@
 import { writable } from 'svelte/store';
 
+export interface TraceStep {
+  kind: 'rewrite' | 'handler' | 'defaultBrowser';
+  index: number;
+  source: 'config' | 'rules';
+  matched: boolean;
+  url?: string;
+  durationMs: number;
+}
+
+export interface Trace {
+  url: string;
+  shortUrl: {
+    original: string;
+    resolved: string;
+    unwrapped?: string[];
+    chain?: { url: string; status?: number }[];
+    error?: string;
+    durationMs: number;
+  };
+  steps: TraceStep[];
+  winner?: TraceStep;
+  fallback?: 'rules' | 'rulesDefault' | 'systemDefault' | 'safari';
+  durationMs: number;
+}
+
 export interface TestUrlResult {
   browser: string;
   url: string;
   openInBackground: boolean;
   profile?: string;
+  error?: string;
+  trace?: Trace;
 }
 
 export const testUrlResult = writable<TestUrlResult | null>(null);
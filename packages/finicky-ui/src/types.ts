@@ -1,7 +1,35 @@
+export interface OpenerCondition {
+  bundleId?: string;
+  name?: string;
+  windowTitle?: string;
+}
+
+export interface RuleWhen {
+  modifiers?: string[];
+  days?: string[];
+  hours?: string;
+  appRunning?: string[];
+}
+
 export interface Rule {
   match: string[];
   browser: string;
   profile?: string;
+  args?: string[];
+  private?: boolean;
+  appWindow?: boolean;
+  fallbacks?: string[];
+  opener?: OpenerCondition;
+  when?: RuleWhen;
+}
+
+export interface Rewrite {
+  match: string | string[];
+  forceHttps?: boolean;
+  host?: string;
+  removeQuery?: string[];
+  setQuery?: Record<string, string>;
+  replace?: { pattern: string; with: string };
 }
 
 export interface ConfigOptions {
@@ -15,6 +43,7 @@ export interface RulesFile {
   defaultBrowser: string;
   defaultProfile?: string;
   options?: Partial<ConfigOptions>;
+  rewrites?: Rewrite[];
   rules: Rule[];
   path?: string;
 }
@@ -53,6 +82,13 @@ export interface UpdateInfo {
   releaseUrl: string;
 }
 
+export interface ConfigError {
+  message: string;
+  file?: string;
+  line?: number;
+  column?: number;
+}
+
 export interface ConfigInfo {
   configPath: string;
   isJSConfig?: boolean;